@@ -0,0 +1,214 @@
+package goqkit
+
+import (
+	"fmt"
+	"github.com/takezo5096/goqkit/mat"
+	"math/cmplx"
+)
+
+/*
+Observable which can be measured as an expectation value.
+
+Apply must return O|v> for the Hermitian operator O without modifying v.
+*/
+type Observable interface {
+	Apply(v mat.Vector) mat.Vector
+}
+
+/*
+Observable which is diagonal in the computational basis.
+
+Element i is the eigenvalue of the basis state |i>.
+*/
+type DiagonalObservable []float64
+
+func (o DiagonalObservable) Apply(v mat.Vector) mat.Vector {
+	w := mat.NewVector(v.N)
+	for i := range v.Data {
+		w.Data[i] = complex(o[i], 0) * v.Data[i]
+	}
+	return w
+}
+
+/*
+Make the observable whose expectation value is the probability of the qbit to be 1.
+
+n: number of qbits in the circuit
+
+qbit: global qbit value (only one bit)
+*/
+func ProbabilityObservable(n uint, qbit uint) DiagonalObservable {
+	o := make(DiagonalObservable, 1<<n)
+	for i := range o {
+		if uint(i)&qbit != 0 {
+			o[i] = 1
+		}
+	}
+	return o
+}
+
+/*
+Make the Pauli Z observable on the qbit.
+
+n: number of qbits in the circuit
+
+qbit: global qbit value (only one bit)
+*/
+func ZObservable(n uint, qbit uint) DiagonalObservable {
+	o := make(DiagonalObservable, 1<<n)
+	for i := range o {
+		if uint(i)&qbit != 0 {
+			o[i] = -1
+		} else {
+			o[i] = 1
+		}
+	}
+	return o
+}
+
+/*
+Return the expectation value <psi|O|psi> of the current qbits.
+*/
+func (q *QBitsCircuit) Expectation(o Observable) float64 {
+	return real(innerProduct(q.RawQBits, o.Apply(q.RawQBits)))
+}
+
+/*
+Return true if the recorded operation depends on a continuous parameter (Rotate and Phase).
+*/
+func (op Operation) IsParametric() bool {
+	return op.OpName == OperationTypeRotate || op.OpName == OperationTypePhase
+}
+
+/*
+Return indexes of the parametric operations in ops.
+
+The gradient returned by AdjointGradient is ordered in the same way.
+*/
+func ParametricOperationIndexes(ops []Operation) []int {
+	idxs := make([]int, 0)
+	for i, op := range ops {
+		if op.IsParametric() {
+			idxs = append(idxs, i)
+		}
+	}
+	return idxs
+}
+
+/*
+Differentiate the expectation value of o with respect to every parametric operation recorded in this circuit.
+
+See AdjointGradient.
+*/
+func (q *QBitsCircuit) AdjointGradient(o Observable) ([]float64, error) {
+	return adjointGradient(q.RawQBits, q.GetOperations(), o, q.GateRegistry())
+}
+
+/*
+Differentiate the expectation value of o by the adjoint method.
+
+state: the state after all of ops were applied
+
+ops: recorded operations which made the state. Read and Write are not allowed.
+
+The gradient has one element for each parametric operation in the order of ParametricOperationIndexes
+and is taken with respect to the angle in radians, even though operations record degrees.
+It costs about one forward and one backward pass over ops regardless of the number of parameters.
+User defined gates are looked up in DefaultGateRegistry.
+*/
+func AdjointGradient(state mat.Vector, ops []Operation, o Observable) ([]float64, error) {
+	return adjointGradient(state, ops, o, nil)
+}
+
+func adjointGradient(state mat.Vector, ops []Operation, o Observable, registry *GateRegistry) ([]float64, error) {
+	psi := copyVector(state)
+	lambda := o.Apply(psi)
+
+	grad := make([]float64, len(ParametricOperationIndexes(ops)))
+	g := len(grad) - 1
+
+	for k := len(ops) - 1; k >= 0; k-- {
+		op := ops[k]
+		if !op.IsUnitary() {
+			return nil, fmt.Errorf("adjoint gradient: operation %d (%q) is not unitary", k, op.OpName)
+		}
+		if err := applyOperation(&psi, op, true, registry); err != nil {
+			return nil, err
+		}
+		if op.IsParametric() {
+			mu, err := applyDerivative(psi, op)
+			if err != nil {
+				return nil, err
+			}
+			grad[g] = 2 * real(innerProduct(lambda, mu))
+			g--
+		}
+		if err := applyOperation(&lambda, op, true, registry); err != nil {
+			return nil, err
+		}
+	}
+	return grad, nil
+}
+
+/*
+Return dU/dtheta|v> for a parametric operation U, with theta in radians.
+
+The derivative of a controlled gate vanishes outside the subspace where all controls are 1.
+*/
+func applyDerivative(v mat.Vector, op Operation) (mat.Vector, error) {
+	m, err := operationMatrix(op)
+	if err != nil {
+		return mat.Vector{}, err
+	}
+
+	var d mat.Matrix
+	if op.OpName == OperationTypePhase {
+		// d/dtheta diag(1, e^itheta) = diag(0, i e^itheta)
+		d = newMatrix2(0, 0, 0, complex(0, 1)*m.At(1, 1))
+	} else {
+		// d/dtheta exp(-i theta sigma/2) = -i/2 sigma exp(-i theta sigma/2)
+		var sigma mat.Matrix
+		switch rotationAxisIndex(op) {
+		case 0:
+			sigma = newMatrix2(0, 1, 1, 0)
+		case 1:
+			sigma = newMatrix2(0, complex(0, -1), complex(0, 1), 0)
+		default:
+			sigma = newMatrix2(1, 0, 0, -1)
+		}
+		d = mat.NewMatrix(2, 2)
+		var i, j uint
+		for i = 0; i < 2; i++ {
+			for j = 0; j < 2; j++ {
+				e := sigma.At(i, 0)*m.At(0, j) + sigma.At(i, 1)*m.At(1, j)
+				d.Set(i, j, complex(0, -0.5)*e)
+			}
+		}
+	}
+
+	w := mat.NewVector(v.N)
+	control := op.ControlValue()
+	target := op.TargetQBit
+	var i uint
+	for i = 0; i < v.N; i++ {
+		if i&target != 0 || int(i)&control != control {
+			continue
+		}
+		a0 := v.Data[i]
+		a1 := v.Data[i|target]
+		w.Data[i] = d.At(0, 0)*a0 + d.At(0, 1)*a1
+		w.Data[i|target] = d.At(1, 0)*a0 + d.At(1, 1)*a1
+	}
+	return w, nil
+}
+
+/*
+Return <a|b>.
+*/
+func innerProduct(a, b mat.Vector) complex128 {
+	var s complex128
+	for i := range a.Data {
+		s += cmplx.Conj(a.Data[i]) * b.Data[i]
+	}
+	return s
+}
@@ -0,0 +1,188 @@
+package goqkit
+
+import (
+	"fmt"
+	"github.com/takezo5096/goqkit/util/queue"
+	"math/cmplx"
+	"sort"
+)
+
+/*
+Largest probability of reading 1 from released ancillas which is taken as |0>.
+*/
+const ancillaTolerance = 1e-9
+
+/*
+Assign k free qbits as an ancilla register while f runs, and return them to the free qbits afterwards.
+
+The ancillas start in |0> and f must return them to |0>, which is checked on the qbits.
+If they are not |0>, an error is returned and the ancillas stay assigned, so that they are not reused dirty.
+Keep the register which f gets to release it by ReleaseRegister then.
+
+k: number of ancilla qbits
+
+f: the block which uses the ancillas
+*/
+func (q *QBitsCircuit) WithAncillas(k int, f func(a *Register)) error {
+	return q.withAncillas(k, f, nil)
+}
+
+/*
+Assign k free qbits as an ancilla register, and uncompute them automatically.
+
+compute runs first, then use, then the inverse of the gates of compute, so that compute only has to
+write into the ancillas and use only has to read them, e.g. as controls. Afterwards the ancillas are checked
+and returned to the free qbits like WithAncillas.
+
+compute may only call unitary gates. If it calls other operations or the uncompute fails,
+the ancillas are reset to |0> and released with the error.
+*/
+func (q *QBitsCircuit) WithAncillasUncompute(k int, compute func(a *Register), use func(a *Register)) error {
+	if use == nil {
+		use = func(a *Register) {}
+	}
+	return q.withAncillas(k, compute, use)
+}
+
+func (q *QBitsCircuit) withAncillas(k int, compute func(a *Register), use func(a *Register)) error {
+	a, err := q.AllocateQBits(k, "")
+	if err != nil {
+		return fmt.Errorf("ancillas: %v", err)
+	}
+	a.Name = fmt.Sprintf("Anc%d", len(q.qBitRegisters))
+
+	start := len(q.operations)
+	compute(a)
+	if use != nil {
+		s, err := NewSubCircuit(q.operations[start:])
+		if err != nil {
+			return q.releaseAncillasAfter(a, fmt.Errorf("compute %v", err))
+		}
+		use(a)
+		if err := q.ApplyInverse(s, 0); err != nil {
+			return q.releaseAncillasAfter(a, fmt.Errorf("uncompute %v", err))
+		}
+	}
+
+	if p := q.probabilityNotZero(a.qBits); p > ancillaTolerance {
+		return fmt.Errorf("ancillas %q stay assigned: they are not |0> (probability %g of reading 1)", a.Name, p)
+	}
+	q.releaseRegister(a)
+	return nil
+}
+
+/*
+Reset and release the ancillas after the error of compute or uncompute, and return the error.
+*/
+func (q *QBitsCircuit) releaseAncillasAfter(a *Register, cause error) error {
+	if err := q.ReleaseRegister(a); err != nil {
+		return fmt.Errorf("ancillas %q stay assigned: %v, release %v", a.Name, cause, err)
+	}
+	return fmt.Errorf("ancillas %q are reset and released: %v", a.Name, cause)
+}
+
+/*
+Reset the qbits of a register to |0> and return them to the free qbits,
+e.g. the ancillas which stay assigned after an error of WithAncillas.
+
+The register has no qbits afterwards. Views and registers of other circuits can not be released,
+and nothing is released while the circuit has an error, because the qbits can not be reset then.
+*/
+func (q *QBitsCircuit) ReleaseRegister(reg *Register) error {
+	if q.err != nil {
+		return fmt.Errorf("register %q is not released after an error: %v", reg.Name, q.err)
+	}
+	found := false
+	for _, r := range q.qBitRegisters {
+		if r == reg {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("register %q is not a register of this circuit", reg.Name)
+	}
+	q.Reset(int(reg.qBits))
+	q.releaseRegister(reg)
+	return nil
+}
+
+/*
+Return the probability that any of the qbits specified as the global value mask reads 1.
+*/
+func (q *QBitsCircuit) probabilityNotZero(mask uint) float64 {
+	p := 0.0
+	for i, amp := range q.RawQBits.Data {
+		if uint(i)&mask != 0 {
+			a := cmplx.Abs(amp)
+			p += a * a
+		}
+	}
+	return p
+}
+
+/*
+Remove the register from this circuit and return its qbits to the free qbits.
+
+The register has no qbits afterwards, so that gates on it make errors.
+*/
+func (q *QBitsCircuit) releaseRegister(reg *Register) {
+	for i, r := range q.qBitRegisters {
+		if r == reg {
+			q.qBitRegisters = append(q.qBitRegisters[:i], q.qBitRegisters[i+1:]...)
+			break
+		}
+	}
+	free := q.freeQBitList()
+	for _, qb := range qbitList(int(reg.qBits)) {
+		free = append(free, int(qb))
+	}
+	// the lowest free qbits are assigned first
+	sort.Ints(free)
+	q.qBitsQueue = queue.Queue{}
+	for _, qb := range free {
+		q.qBitsQueue.Enqueue(qb)
+	}
+	reg.qBits = 0
+	reg.qbitList = nil
+	reg.numberOfQBits = 0
+}
+
+/*
+Assign the free qbits in the order of qbitList as a register.
+*/
+func (q *QBitsCircuit) assignQBitList(qbitList []uint, name string) (*Register, error) {
+	free := q.freeQBitList()
+	rest := make(map[int]bool)
+	for _, qb := range free {
+		rest[qb] = true
+	}
+	for _, qb := range qbitList {
+		if !rest[int(qb)] {
+			return nil, fmt.Errorf("register %q: qbit %d is not free", name, qbitIndex(qb))
+		}
+		delete(rest, int(qb))
+	}
+	q.qBitsQueue = queue.Queue{}
+	for _, qb := range free {
+		if rest[qb] {
+			q.qBitsQueue.Enqueue(qb)
+		}
+	}
+	reg := q.newRegister(append([]uint{}, qbitList...), name)
+	q.qBitRegisters = append(q.qBitRegisters, reg)
+	return reg, nil
+}
+
+/*
+Return the global values of the free qbits in the order in which they are assigned.
+*/
+func (q *QBitsCircuit) freeQBitList() []int {
+	var free []int
+	for q.qBitsQueue.Size() > 0 {
+		free = append(free, q.qBitsQueue.Dequeue())
+	}
+	for _, qb := range free {
+		q.qBitsQueue.Enqueue(qb)
+	}
+	return free
+}
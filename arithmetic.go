@@ -0,0 +1,689 @@
+package goqkit
+
+import (
+	"fmt"
+)
+
+/*
+Add (or subtract) a constant to the qbits in the order from the lowest digit, modulo 2^len(qbits).
+
+Every increment is controlled by all qbits of control.
+*/
+func (q *QBitsCircuit) addConstQBits(qbits []uint, val int, control int, subtract bool) {
+	for i := range qbits {
+		if (val>>uint(i))&1 == 0 {
+			continue
+		}
+		if subtract {
+			q.decrementQBits(qbits[i:], control)
+		} else {
+			q.incrementQBits(qbits[i:], control)
+		}
+	}
+}
+
+/*
+Add (or subtract) the value of the src qbits multiplied by 2^shift to the target qbits,
+both in the order from the lowest digit, modulo 2^len(target).
+*/
+func (q *QBitsCircuit) addQBits(target []uint, src []uint, shift int, control int, subtract bool) {
+	for i, s := range src {
+		if i+shift >= len(target) {
+			break
+		}
+		if subtract {
+			q.decrementQBits(target[i+shift:], control|int(s))
+		} else {
+			q.incrementQBits(target[i+shift:], control|int(s))
+		}
+	}
+}
+
+/*
+Return an error if registers share qbits.
+*/
+func disjointRegisters(regs ...*Register) error {
+	used := uint(0)
+	for _, reg := range regs {
+		if used&reg.qBits != 0 {
+			return fmt.Errorf("register %q shares qbits with another operand", reg.Name)
+		}
+		used |= reg.qBits
+	}
+	return nil
+}
+
+/*
+Return an error if the flag is invalid or one of the qbits of the registers.
+*/
+func (reg *Register) checkFlag(flag QBit, regs ...*Register) error {
+	if _, ok := reg.circuit.maskOf(flag); !ok {
+		return reg.circuit.Err()
+	}
+	for _, r := range regs {
+		if r.qBits&uint(flag.Value()) != 0 {
+			return fmt.Errorf("flag qbit %d is a qbit of register %q", flag.Index(), r.Name)
+		}
+	}
+	return nil
+}
+
+/*
+Add the value of register x multiplied by the constant c to this register, modulo 2^n.
+
+Negative c subtracts, and with two's complement values the result is also the signed product modulo 2^n.
+
+control: global control qbits value
+*/
+func (reg *Register) AddProduct(x *Register, c int, control int) error {
+	if c < 0 {
+		return reg.SubtractProduct(x, -c, control)
+	}
+	return reg.addProduct(x, c, control, false)
+}
+
+/*
+Subtract the value of register x multiplied by the constant c from this register, modulo 2^n.
+
+This is the inverse of AddProduct.
+*/
+func (reg *Register) SubtractProduct(x *Register, c int, control int) error {
+	if c < 0 {
+		return reg.AddProduct(x, -c, control)
+	}
+	return reg.addProduct(x, c, control, true)
+}
+
+func (reg *Register) addProduct(x *Register, c int, control int, subtract bool) error {
+	if err := disjointRegisters(reg, x); err != nil {
+		return err
+	}
+	for j := 0; j < reg.numberOfQBits; j++ {
+		if (c>>uint(j))&1 != 0 {
+			reg.circuit.addQBits(reg.qbitList, x.qbitList, j, control, subtract)
+		}
+	}
+	return nil
+}
+
+/*
+Add the product of the registers x and y to this register, modulo 2^n.
+
+control: global control qbits value
+*/
+func (reg *Register) AddProductRegister(x *Register, y *Register, control int) error {
+	return reg.addProductRegister(x, y, control, false)
+}
+
+/*
+Subtract the product of the registers x and y from this register, modulo 2^n.
+
+This is the inverse of AddProductRegister.
+*/
+func (reg *Register) SubtractProductRegister(x *Register, y *Register, control int) error {
+	return reg.addProductRegister(x, y, control, true)
+}
+
+func (reg *Register) addProductRegister(x *Register, y *Register, control int, subtract bool) error {
+	if err := disjointRegisters(reg, x, y); err != nil {
+		return err
+	}
+	for j, yq := range y.qbitList {
+		reg.circuit.addQBits(reg.qbitList, x.qbitList, j, control|int(yq), subtract)
+	}
+	return nil
+}
+
+/*
+Multiply this register by the odd constant c in place, modulo 2^n.
+
+n ancillas are used: the product is computed into them, swapped with this register,
+and the ancillas are cleared by subtracting the product with the inverse of c.
+*/
+func (reg *Register) MultiplyConst(c int, control int) error {
+	n := reg.numberOfQBits
+	mod := 1 << uint(n)
+	c = ((c % mod) + mod) % mod
+	if c%2 == 0 {
+		return fmt.Errorf("register %q: %d is not invertible modulo 2^%d", reg.Name, c, n)
+	}
+	inv, _ := modInverse(c, mod)
+	var inner error
+	err := reg.circuit.WithAncillas(n, func(a *Register) {
+		if inner = a.AddProduct(reg, c, control); inner != nil {
+			return
+		}
+		for i := range reg.qbitList {
+			reg.circuit.Swap(int(reg.qbitList[i]), int(a.qbitList[i]), control)
+		}
+		inner = a.SubtractProduct(reg, inv, control)
+	})
+	if inner != nil {
+		return inner
+	}
+	return err
+}
+
+/*
+Add the constant a to this register modulo N, where the value of this register is less than N.
+
+Two ancillas are used, one as the sign of the sum and one as the flag of the reduction.
+
+a: constant, taken modulo N
+
+N: modulus, at most 2^n
+
+control: global control qbits value
+*/
+func (reg *Register) AddMod(a int, N int, control int) error {
+	if N < 1 || N > 1<<uint(reg.numberOfQBits) {
+		return fmt.Errorf("register %q: modulus %d is out of range of %d qbits", reg.Name, N, reg.numberOfQBits)
+	}
+	a = ((a % N) + N) % N
+	q := reg.circuit
+	return q.WithAncillas(2, func(anc *Register) {
+		top := anc.qbitList[0]
+		flag := anc.qbitList[1]
+		ext := append(append([]uint{}, reg.qbitList...), top)
+
+		q.addConstQBits(ext, a, control, false)
+		q.addConstQBits(ext, N, control, true)
+		// top is 1 when x+a < N, then N is added back
+		q.Not(int(flag), int(top))
+		q.addConstQBits(ext, N, int(flag), false)
+
+		// the flag is 1 exactly when the result is not less than a
+		q.addConstQBits(ext, a, control, true)
+		q.Not(int(top), 0)
+		q.Not(int(flag), int(top)|control)
+		q.Not(int(top), 0)
+		q.addConstQBits(ext, a, control, false)
+	})
+}
+
+/*
+Subtract the constant a from this register modulo N, the inverse of AddMod.
+*/
+func (reg *Register) SubtractMod(a int, N int, control int) error {
+	if N < 1 {
+		return fmt.Errorf("register %q: modulus %d is out of range", reg.Name, N)
+	}
+	return reg.AddMod(N-((a%N)+N)%N, N, control)
+}
+
+/*
+Multiply this register by the constant a in place modulo N, where gcd(a, N) = 1
+and the value of this register is less than N.
+
+n+2 ancillas are used: a x mod N is computed into n of them with AddMod, swapped with this register,
+and the ancillas are cleared by subtracting x again with the inverse of a.
+*/
+func (reg *Register) MultiplyMod(a int, N int, control int) error {
+	return reg.MultiplyModWith(a, N, control, AdderRipple)
+}
+
+/*
+MultiplyMod with the adder of the modular additions.
+
+With AdderDraper the ancillas of the product and its sign stay in Fourier space for all modular additions
+of a x mod N and again for all subtractions of x, so the product leaves Fourier space only for the swap
+and for the sign copies of every addition, see AddModWith.
+*/
+func (reg *Register) MultiplyModWith(a int, N int, control int, adder Adder) error {
+	if N < 1 || N > 1<<uint(reg.numberOfQBits) {
+		return fmt.Errorf("register %q: modulus %d is out of range of %d qbits", reg.Name, N, reg.numberOfQBits)
+	}
+	a = ((a % N) + N) % N
+	inv, ok := modInverse(a, N)
+	if !ok {
+		return fmt.Errorf("register %q: %d is not invertible modulo %d", reg.Name, a, N)
+	}
+	q := reg.circuit
+	switch adder {
+	case AdderRipple:
+	case AdderDraper:
+		n := reg.numberOfQBits
+		return q.WithAncillas(n+2, func(anc *Register) {
+			// the product y with its sign qbit, and the flag of the modular additions
+			ext := anc.qbitList[:n+1]
+			flag := anc.qbitList[n+1]
+
+			q.qft(ext)
+			factor := a
+			for _, xq := range reg.qbitList {
+				q.phaseAddMod(ext, factor, N, control|int(xq), flag)
+				factor = factor * 2 % N
+			}
+			q.inversedQFT(ext)
+			for i := range reg.qbitList {
+				q.Swap(int(reg.qbitList[i]), int(ext[i]), control)
+			}
+			q.qft(ext)
+			factor = inv
+			for _, xq := range reg.qbitList {
+				q.phaseAddMod(ext, (N-factor)%N, N, control|int(xq), flag)
+				factor = factor * 2 % N
+			}
+			q.inversedQFT(ext)
+		})
+	default:
+		return fmt.Errorf("unknown adder %d", adder)
+	}
+	var inner error
+	err := q.WithAncillas(reg.numberOfQBits, func(y *Register) {
+		factor := a
+		for _, xq := range reg.qbitList {
+			if inner = y.AddModWith(factor, N, control|int(xq), adder); inner != nil {
+				return
+			}
+			factor = factor * 2 % N
+		}
+		for i := range reg.qbitList {
+			q.Swap(int(reg.qbitList[i]), int(y.qbitList[i]), control)
+		}
+		factor = inv
+		for _, xq := range reg.qbitList {
+			if inner = y.SubtractModWith(factor, N, control|int(xq), adder); inner != nil {
+				return
+			}
+			factor = factor * 2 % N
+		}
+	})
+	if inner != nil {
+		return inner
+	}
+	return err
+}
+
+/*
+Flip the flag qbit if the value of this register is less than the value of register b (unsigned).
+
+One ancilla more than the wider register is used for the borrow of this register minus b.
+*/
+func (reg *Register) LessThan(b *Register, flag QBit) error {
+	if err := disjointRegisters(reg, b); err != nil {
+		return err
+	}
+	if err := reg.checkFlag(flag, reg, b); err != nil {
+		return err
+	}
+	q := reg.circuit
+	extra := 1
+	if b.numberOfQBits > reg.numberOfQBits {
+		extra += b.numberOfQBits - reg.numberOfQBits
+	}
+	return q.WithAncillas(extra, func(anc *Register) {
+		ext := append(append([]uint{}, reg.qbitList...), anc.qbitList...)
+		q.addQBits(ext, b.qbitList, 0, 0, true)
+		q.Not(flag.Value(), int(ext[len(ext)-1]))
+		q.addQBits(ext, b.qbitList, 0, 0, false)
+	})
+}
+
+/*
+Flip the flag qbit if the value of this register is less than the non negative constant c (unsigned).
+*/
+func (reg *Register) LessThanConst(c int, flag QBit) error {
+	if c < 0 {
+		return fmt.Errorf("register %q: constant %d is negative, see LessThanSigned", reg.Name, c)
+	}
+	if err := reg.checkFlag(flag, reg); err != nil {
+		return err
+	}
+	q := reg.circuit
+	extra := 1
+	for c>>uint(reg.numberOfQBits+extra-1) != 0 {
+		extra++
+	}
+	return q.WithAncillas(extra, func(anc *Register) {
+		ext := append(append([]uint{}, reg.qbitList...), anc.qbitList...)
+		q.addConstQBits(ext, c, 0, true)
+		q.Not(flag.Value(), int(ext[len(ext)-1]))
+		q.addConstQBits(ext, c, 0, false)
+	})
+}
+
+/*
+Flip the flag qbit if the value of this register is less than the value of register b,
+both as two's complement signed values of the same number of qbits.
+*/
+func (reg *Register) LessThanSigned(b *Register, flag QBit) error {
+	if reg.numberOfQBits != b.numberOfQBits || reg.numberOfQBits == 0 {
+		return fmt.Errorf("registers %q and %q must have the same number of qbits", reg.Name, b.Name)
+	}
+	if err := disjointRegisters(reg, b); err != nil {
+		return err
+	}
+	// flipping the sign bits maps the signed order to the unsigned order
+	signs := int(reg.qbitList[reg.numberOfQBits-1] | b.qbitList[b.numberOfQBits-1])
+	reg.circuit.Not(signs, 0)
+	err := reg.LessThan(b, flag)
+	reg.circuit.Not(signs, 0)
+	return err
+}
+
+/*
+Flip the flag qbit if the value of this register equals the value of register b of the same number of qbits.
+*/
+func (reg *Register) Equal(b *Register, flag QBit) error {
+	if reg.numberOfQBits != b.numberOfQBits {
+		return fmt.Errorf("registers %q and %q must have the same number of qbits", reg.Name, b.Name)
+	}
+	if err := disjointRegisters(reg, b); err != nil {
+		return err
+	}
+	if err := reg.checkFlag(flag, reg, b); err != nil {
+		return err
+	}
+	q := reg.circuit
+	for i := range reg.qbitList {
+		q.Not(int(reg.qbitList[i]), int(b.qbitList[i]))
+	}
+	// this register is 0 exactly when both are equal
+	all := int(reg.qBits)
+	q.Not(all, 0)
+	q.Not(flag.Value(), all)
+	q.Not(all, 0)
+	for i := range reg.qbitList {
+		q.Not(int(reg.qbitList[i]), int(b.qbitList[i]))
+	}
+	return nil
+}
+
+/*
+Flip the flag qbit if the value of this register equals the constant c modulo 2^n,
+so that a negative c is compared as a two's complement value.
+*/
+func (reg *Register) EqualConst(c int, flag QBit) error {
+	if err := reg.checkFlag(flag, reg); err != nil {
+		return err
+	}
+	q := reg.circuit
+	zeros := reg.ToGlobalQBits(^c & (1<<uint(reg.numberOfQBits) - 1))
+	if zeros != 0 {
+		q.Not(zeros, 0)
+	}
+	q.Not(flag.Value(), int(reg.qBits))
+	if zeros != 0 {
+		q.Not(zeros, 0)
+	}
+	return nil
+}
+
+/*
+Negate the value of this register as a two's complement value, x to -x modulo 2^n.
+
+control: global control qbits value
+*/
+func (reg *Register) Negate(control int) {
+	for _, qb := range reg.qbitList {
+		reg.circuit.Not(int(qb), control)
+	}
+	reg.circuit.incrementQBits(reg.qbitList, control)
+}
+
+/*
+Return the two's complement value of n bits of a signed value, e.g. FromSigned(-1, 4) is 15.
+*/
+func FromSigned(v int, n int) int {
+	mask := 1<<uint(n) - 1
+	return v & mask
+}
+
+/*
+Return the signed value of an n bits two's complement value, e.g. ToSigned(15, 4) is -1.
+*/
+func ToSigned(u int, n int) int {
+	u &= 1<<uint(n) - 1
+	if u>>uint(n-1) != 0 {
+		return u - 1<<uint(n)
+	}
+	return u
+}
+
+/*
+Return the inverse of a modulo m and true, or false if a is not invertible.
+*/
+func modInverse(a int, m int) (int, bool) {
+	if m == 1 {
+		return 0, true
+	}
+	t, newT := 0, 1
+	r, newR := m, ((a%m)+m)%m
+	for newR != 0 {
+		quotient := r / newR
+		t, newT = newT, t-quotient*newT
+		r, newR = newR, r-quotient*newR
+	}
+	if r != 1 {
+		return 0, false
+	}
+	return ((t % m) + m) % m, true
+}
+
+/*
+Kind of adder which the arithmetic of registers is built on.
+*/
+type Adder int
+
+const (
+	//Cascade of multi controlled Not gates, see Register.Add
+	AdderRipple Adder = iota
+	//Controlled phase gates between QFT and InversedQFT (Draper adder)
+	AdderDraper
+)
+
+/*
+Add (or subtract) a constant to the qbits in Fourier space, after QFT of the qbits.
+
+One phase gate per qbit, controlled by all qbits of control.
+*/
+func (q *QBitsCircuit) phaseAddConst(qbits []uint, val int, control int, subtract bool) {
+	n := uint(len(qbits))
+	mod := 1 << n
+	val = ((val % mod) + mod) % mod
+	if subtract {
+		val = (mod - val) % mod
+	}
+	for j, qb := range qbits {
+		// the j-th qbit has the phase -2 pi val 2^j / 2^n, the sign of the phases of QFT
+		deg := normalizeDegree(-360 * float64((val<<uint(j))%mod) / float64(mod))
+		if deg != 0 {
+			q.Phase(int(qb), control, deg)
+		}
+	}
+}
+
+/*
+Run f with the function which adds (or subtracts) a constant to the qbits with the adder.
+
+The Draper adder transforms the qbits only once around all additions of f,
+so f must not use the qbits otherwise.
+*/
+func (q *QBitsCircuit) withAdder(qbits []uint, adder Adder, subtract bool, f func(add func(val int, control int))) error {
+	switch adder {
+	case AdderRipple:
+		f(func(val int, control int) {
+			q.addConstQBits(qbits, val, control, subtract)
+		})
+	case AdderDraper:
+		q.qft(qbits)
+		f(func(val int, control int) {
+			q.phaseAddConst(qbits, val, control, subtract)
+		})
+		q.inversedQFT(qbits)
+	default:
+		return fmt.Errorf("unknown adder %d", adder)
+	}
+	return nil
+}
+
+/*
+Add the constant val to this register modulo 2^n with the adder.
+
+Unlike Add, all additions are controlled by all qbits of control.
+
+control: global control qbits value
+*/
+func (reg *Register) AddWith(val int, control int, adder Adder) error {
+	return reg.circuit.withAdder(reg.qbitList, adder, false, func(add func(int, int)) {
+		add(val, control)
+	})
+}
+
+/*
+Subtract the constant val from this register modulo 2^n with the adder, the inverse of AddWith.
+*/
+func (reg *Register) SubtractWith(val int, control int, adder Adder) error {
+	return reg.circuit.withAdder(reg.qbitList, adder, true, func(add func(int, int)) {
+		add(val, control)
+	})
+}
+
+/*
+Add the value of register b to this register modulo 2^n with the adder.
+
+control: global control qbits value
+*/
+func (reg *Register) AddRegisterWith(b *Register, control int, adder Adder) error {
+	return reg.addProductWith(b, 1, control, adder, false)
+}
+
+/*
+Subtract the value of register b from this register modulo 2^n with the adder, the inverse of AddRegisterWith.
+*/
+func (reg *Register) SubtractRegisterWith(b *Register, control int, adder Adder) error {
+	return reg.addProductWith(b, 1, control, adder, true)
+}
+
+/*
+AddProduct with the adder.
+
+With AdderDraper this is the QFT multiplier: every bit of x adds c 2^i by phase gates between one QFT
+and one InversedQFT of this register.
+*/
+func (reg *Register) AddProductWith(x *Register, c int, control int, adder Adder) error {
+	if c < 0 {
+		return reg.addProductWith(x, -c, control, adder, true)
+	}
+	return reg.addProductWith(x, c, control, adder, false)
+}
+
+/*
+SubtractProduct with the adder, the inverse of AddProductWith.
+*/
+func (reg *Register) SubtractProductWith(x *Register, c int, control int, adder Adder) error {
+	if c < 0 {
+		return reg.addProductWith(x, -c, control, adder, false)
+	}
+	return reg.addProductWith(x, c, control, adder, true)
+}
+
+func (reg *Register) addProductWith(x *Register, c int, control int, adder Adder, subtract bool) error {
+	if err := disjointRegisters(reg, x); err != nil {
+		return err
+	}
+	return reg.circuit.withAdder(reg.qbitList, adder, subtract, func(add func(int, int)) {
+		for i, xq := range x.qbitList {
+			if i < reg.numberOfQBits {
+				add(c<<uint(i), control|int(xq))
+			}
+		}
+	})
+}
+
+/*
+AddProductRegister with the adder.
+*/
+func (reg *Register) AddProductRegisterWith(x *Register, y *Register, control int, adder Adder) error {
+	return reg.addProductRegisterWith(x, y, control, adder, false)
+}
+
+/*
+SubtractProductRegister with the adder, the inverse of AddProductRegisterWith.
+*/
+func (reg *Register) SubtractProductRegisterWith(x *Register, y *Register, control int, adder Adder) error {
+	return reg.addProductRegisterWith(x, y, control, adder, true)
+}
+
+func (reg *Register) addProductRegisterWith(x *Register, y *Register, control int, adder Adder, subtract bool) error {
+	if err := disjointRegisters(reg, x, y); err != nil {
+		return err
+	}
+	return reg.circuit.withAdder(reg.qbitList, adder, subtract, func(add func(int, int)) {
+		for j, yq := range y.qbitList {
+			for i, xq := range x.qbitList {
+				if i+j < reg.numberOfQBits {
+					add(1<<uint(i+j), control|int(xq)|int(yq))
+				}
+			}
+		}
+	})
+}
+
+/*
+AddMod with the adder.
+
+With AdderDraper this is the modular adder of Beauregard: the sum stays in Fourier space
+except when the sign is copied to the flag ancilla, see phaseAddMod.
+*/
+func (reg *Register) AddModWith(a int, N int, control int, adder Adder) error {
+	switch adder {
+	case AdderRipple:
+		return reg.AddMod(a, N, control)
+	case AdderDraper:
+	default:
+		return fmt.Errorf("unknown adder %d", adder)
+	}
+	if N < 1 || N > 1<<uint(reg.numberOfQBits) {
+		return fmt.Errorf("register %q: modulus %d is out of range of %d qbits", reg.Name, N, reg.numberOfQBits)
+	}
+	a = ((a % N) + N) % N
+	q := reg.circuit
+	return q.WithAncillas(2, func(anc *Register) {
+		ext := append(append([]uint{}, reg.qbitList...), anc.qbitList[0])
+		q.qft(ext)
+		q.phaseAddMod(ext, a, N, control, anc.qbitList[1])
+		q.inversedQFT(ext)
+	})
+}
+
+/*
+Add the constant a (0 <= a <= N) modulo N to the qbits in Fourier space, after QFT of the qbits.
+
+The highest qbit of ext is the sign of the sum and must be |0> like flag before QFT, the others hold a value less than N.
+ext leaves Fourier space only twice, to copy the sign to the flag qbit and to clear the flag again,
+and both are |0> afterwards.
+
+control: global control qbits value
+*/
+func (q *QBitsCircuit) phaseAddMod(ext []uint, a int, N int, control int, flag uint) {
+	top := ext[len(ext)-1]
+
+	q.phaseAddConst(ext, a, control, false)
+	q.phaseAddConst(ext, N, 0, true)
+	// top is 1 when x+a < N, then N is added back
+	q.inversedQFT(ext)
+	q.Not(int(flag), int(top))
+	q.qft(ext)
+	q.phaseAddConst(ext, N, int(flag), false)
+
+	// the flag is 1 exactly when the result is not less than a
+	q.phaseAddConst(ext, a, control, true)
+	q.inversedQFT(ext)
+	q.Not(int(top), 0)
+	q.Not(int(flag), int(top))
+	q.Not(int(top), 0)
+	q.qft(ext)
+	q.phaseAddConst(ext, a, control, false)
+}
+
+/*
+SubtractMod with the adder, the inverse of AddModWith.
+*/
+func (reg *Register) SubtractModWith(a int, N int, control int, adder Adder) error {
+	if N < 1 {
+		return fmt.Errorf("register %q: modulus %d is out of range", reg.Name, N)
+	}
+	return reg.AddModWith(N-((a%N)+N)%N, N, control, adder)
+}
@@ -0,0 +1,307 @@
+package goqkit_test
+
+import (
+	"fmt"
+	"github.com/takezo5096/goqkit"
+	"github.com/takezo5096/goqkit/qtest"
+	"testing"
+)
+
+var adders = []struct {
+	name  string
+	adder goqkit.Adder
+}{
+	{"ripple", goqkit.AdderRipple},
+	{"draper", goqkit.AdderDraper},
+}
+
+/*
+Registers of n qbits which the arithmetic must handle alike: a plain register,
+a slice which starts above qbit 0 and an alias with the qbits in reverse order.
+*/
+var layouts = []struct {
+	name string
+	make func(q *goqkit.QBitsCircuit, n int, name string) *goqkit.Register
+}{
+	{"register", func(q *goqkit.QBitsCircuit, n int, name string) *goqkit.Register {
+		return q.AssignQBits(n, name)
+	}},
+	{"slice", func(q *goqkit.QBitsCircuit, n int, name string) *goqkit.Register {
+		return q.AssignQBits(n+1, name).Slice(1, n+1)
+	}},
+	{"alias", func(q *goqkit.QBitsCircuit, n int, name string) *goqkit.Register {
+		qs := q.AssignQBits(n, name).Qs()
+		for i, j := 0, len(qs)-1; i < j; i, j = i+1, j-1 {
+			qs[i], qs[j] = qs[j], qs[i]
+		}
+		return q.Alias(name, qs...)
+	}},
+}
+
+/*
+Fail the test if the circuit has an error or the ancillas were not returned to the free qbits.
+*/
+func assertClean(t *testing.T, q *goqkit.QBitsCircuit, free int) {
+	t.Helper()
+	if err := q.Err(); err != nil {
+		t.Errorf("circuit error: %v", err)
+	}
+	if q.FreeQBits() != free {
+		t.Errorf("%d free qbits, expected %d", q.FreeQBits(), free)
+	}
+}
+
+func TestAddMod(t *testing.T) {
+	cases := []struct {
+		n, N, a, x int
+		control    bool
+	}{
+		{3, 5, 3, 4, true},
+		{3, 5, 3, 1, true},
+		{3, 5, 0, 2, true},
+		{3, 5, 4, 4, false},
+		{3, 7, 6, 6, true},
+		{3, 8, 5, 7, true},
+		{4, 11, 9, 10, true},
+		{4, 11, -3, 2, true},
+	}
+	for _, ad := range adders {
+		for _, l := range layouts {
+			for _, c := range cases {
+				name := fmt.Sprintf("%s/%s/N=%d,a=%d,x=%d,control=%v", ad.name, l.name, c.N, c.a, c.x, c.control)
+				t.Run(name, func(t *testing.T) {
+					circuit := goqkit.MakeQBitsCircuit(2*c.n + 4)
+					q := &circuit
+					ctl := q.AssignQBits(1, "c")
+					x := l.make(q, c.n, "x")
+					free := q.FreeQBits()
+					if c.control {
+						ctl.Write(1)
+					}
+					x.Write(c.x)
+
+					if err := x.AddModWith(c.a, c.N, ctl.ToGlobalQBits(1), ad.adder); err != nil {
+						t.Fatal(err)
+					}
+					want := c.x
+					if c.control {
+						want = ((c.x+c.a)%c.N + c.N) % c.N
+					}
+					if got := x.ReadAll(); got != want {
+						t.Errorf("AddModWith: got %d, expected %d", got, want)
+					}
+
+					if err := x.SubtractModWith(c.a, c.N, ctl.ToGlobalQBits(1), ad.adder); err != nil {
+						t.Fatal(err)
+					}
+					if got := x.ReadAll(); got != c.x {
+						t.Errorf("SubtractModWith: got %d, expected %d", got, c.x)
+					}
+					assertClean(t, q, free)
+				})
+			}
+		}
+	}
+}
+
+func TestAddModSuperposition(t *testing.T) {
+	for _, ad := range adders {
+		for _, l := range layouts {
+			t.Run(ad.name+"/"+l.name, func(t *testing.T) {
+				circuit := goqkit.MakeQBitsCircuit(3 + 1 + 2)
+				q := &circuit
+				x := l.make(q, 3, "x")
+				// x is 0, 1, 2 or 3
+				q.Had(x.ToGlobalQBits(3), 0)
+
+				if err := x.AddModWith(3, 5, 0, ad.adder); err != nil {
+					t.Fatal(err)
+				}
+				amplitudes := make(map[uint]complex128)
+				for v := 0; v < 4; v++ {
+					amplitudes[q.BasisIndex(map[*goqkit.Register]int{x: (v + 3) % 5})] = 0.5
+				}
+				qtest.AssertStateClose(t, q, qtest.State(q.QBitNumber, amplitudes), 1e-9)
+			})
+		}
+	}
+}
+
+func TestMultiplyMod(t *testing.T) {
+	cases := []struct {
+		n, N, a, x int
+		control    bool
+	}{
+		{3, 5, 2, 3, true},
+		{3, 5, 4, 4, true},
+		{3, 7, 3, 5, true},
+		{3, 7, 3, 5, false},
+		{3, 8, 5, 6, true},
+		{4, 15, 7, 11, true},
+		{4, 15, 1, 9, true},
+	}
+	for _, ad := range adders {
+		for _, l := range layouts {
+			for _, c := range cases {
+				name := fmt.Sprintf("%s/%s/N=%d,a=%d,x=%d,control=%v", ad.name, l.name, c.N, c.a, c.x, c.control)
+				t.Run(name, func(t *testing.T) {
+					circuit := goqkit.MakeQBitsCircuit(2*c.n + 4)
+					q := &circuit
+					ctl := q.AssignQBits(1, "c")
+					x := l.make(q, c.n, "x")
+					free := q.FreeQBits()
+					if c.control {
+						ctl.Write(1)
+					}
+					x.Write(c.x)
+
+					if err := x.MultiplyModWith(c.a, c.N, ctl.ToGlobalQBits(1), ad.adder); err != nil {
+						t.Fatal(err)
+					}
+					want := c.x
+					if c.control {
+						want = c.a * c.x % c.N
+					}
+					if got := x.ReadAll(); got != want {
+						t.Errorf("got %d, expected %d", got, want)
+					}
+					assertClean(t, q, free)
+				})
+			}
+		}
+	}
+}
+
+func TestMultiplyModNotInvertible(t *testing.T) {
+	circuit := goqkit.MakeQBitsCircuit(4 + 6)
+	x := circuit.AssignQBits(4, "x")
+	for _, ad := range adders {
+		if err := x.MultiplyModWith(6, 15, 0, ad.adder); err == nil {
+			t.Errorf("%s: multiplication by 6 modulo 15 is accepted", ad.name)
+		}
+	}
+}
+
+func TestLessThan(t *testing.T) {
+	cases := []struct {
+		na, nb, a, b int
+	}{
+		{3, 3, 2, 5},
+		{3, 3, 5, 2},
+		{3, 3, 4, 4},
+		{3, 3, 0, 7},
+		{2, 3, 3, 6},
+		{3, 2, 6, 3},
+		{3, 2, 2, 3},
+	}
+	for _, l := range layouts {
+		for _, c := range cases {
+			t.Run(fmt.Sprintf("%s/%d<%d", l.name, c.a, c.b), func(t *testing.T) {
+				circuit := goqkit.MakeQBitsCircuit(c.na + c.nb + 4)
+				q := &circuit
+				a := l.make(q, c.na, "a")
+				b := q.AssignQBits(c.nb, "b")
+				f := q.AssignQBits(1, "f")
+				free := q.FreeQBits()
+				a.Write(c.a)
+				b.Write(c.b)
+
+				if err := a.LessThan(b, f.Q(0)); err != nil {
+					t.Fatal(err)
+				}
+				want := 0
+				if c.a < c.b {
+					want = 1
+				}
+				if got := f.ReadAll(); got != want {
+					t.Errorf("flag %d, expected %d", got, want)
+				}
+				if a.ReadAll() != c.a || b.ReadAll() != c.b {
+					t.Errorf("registers changed to %d and %d", a.ReadAll(), b.ReadAll())
+				}
+				assertClean(t, q, free)
+			})
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	cases := []struct {
+		a, b int
+	}{
+		{0, 0},
+		{5, 5},
+		{5, 4},
+		{1, 6},
+		{7, 7},
+	}
+	for _, l := range layouts {
+		for _, c := range cases {
+			t.Run(fmt.Sprintf("%s/%d==%d", l.name, c.a, c.b), func(t *testing.T) {
+				circuit := goqkit.MakeQBitsCircuit(3 + 1 + 3 + 1 + 4)
+				q := &circuit
+				a := l.make(q, 3, "a")
+				b := q.AssignQBits(3, "b")
+				f := q.AssignQBits(1, "f")
+				free := q.FreeQBits()
+				a.Write(c.a)
+				b.Write(c.b)
+
+				if err := a.Equal(b, f.Q(0)); err != nil {
+					t.Fatal(err)
+				}
+				want := 0
+				if c.a == c.b {
+					want = 1
+				}
+				if got := f.ReadAll(); got != want {
+					t.Errorf("flag %d, expected %d", got, want)
+				}
+				if a.ReadAll() != c.a || b.ReadAll() != c.b {
+					t.Errorf("registers changed to %d and %d", a.ReadAll(), b.ReadAll())
+				}
+				assertClean(t, q, free)
+			})
+		}
+	}
+}
+
+func TestNegate(t *testing.T) {
+	cases := []struct {
+		x       int
+		control bool
+	}{
+		{0, true},
+		{1, true},
+		{5, true},
+		{7, true},
+		{4, true},
+		{5, false},
+	}
+	for _, l := range layouts {
+		for _, c := range cases {
+			t.Run(fmt.Sprintf("%s/x=%d,control=%v", l.name, c.x, c.control), func(t *testing.T) {
+				circuit := goqkit.MakeQBitsCircuit(1 + 3 + 1 + 4)
+				q := &circuit
+				ctl := q.AssignQBits(1, "c")
+				x := l.make(q, 3, "x")
+				if c.control {
+					ctl.Write(1)
+				}
+				x.Write(c.x)
+
+				x.Negate(ctl.ToGlobalQBits(1))
+				want := c.x
+				if c.control {
+					want = (8 - c.x) % 8
+				}
+				if got := x.ReadAll(); got != want {
+					t.Errorf("got %d, expected %d", got, want)
+				}
+				if err := q.Err(); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
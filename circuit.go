@@ -33,6 +33,23 @@ type QBitsCircuit struct {
 	qBitRegisters []*Register
 
 	operations []Operation
+	//Depth of nested Capture calls, gates only record operations while capturing
+	capturing int
+
+	//Values of all classical bits
+	classicalBits      uint
+	classicalRegisters []*ClassicalRegister
+	//Condition of the gates inside CIf
+	condition *Condition
+
+	//First error of gates with invalid qbits, see Err
+	err error
+
+	//Registry of the user defined gates, DefaultGateRegistry when nil
+	gates *GateRegistry
+
+	//Random numbers of reads, math/rand seeded by the time of each read when nil
+	rnd *rand.Rand
 
 	printBuffer string
 }
@@ -41,6 +58,7 @@ const (
 	OperationTypeSpace  = "Sp"
 	OperationTypeRead   = "R"
 	OperationTypeWrite  = "W"
+	OperationTypeReset  = "Rs"
 	OperationTypeHad    = "H"
 	OperationTypePhase  = "P"
 	OperationTypeRotate = "Ro"
@@ -49,23 +67,33 @@ const (
 	OperationTypeX      = "X"
 	OperationTypeY      = "Y"
 	OperationTypeZ      = "Z"
+	OperationTypeU3     = "U3"
+	OperationTypeSX     = "SX"
+	OperationTypeGate   = "G" // user defined gate of the gate registry of the circuit
 )
 
 type Operation struct {
-	OpName             string    `json:"op_name"`
-	RegisterName       int       `json:"register_name"`
-	RegisterNameString string    `json:"register_name_string"`
-	TargetQBit         uint      `json:"target_qbit"`
-	ControlQBits       []uint    `json:"control_qbits"`
-	SwapQBit           uint      `json:"swap_qbit"`
-	Options            []float64 `json:"options"`
+	OpName             string     `json:"op_name"`
+	RegisterName       int        `json:"register_name"`
+	RegisterNameString string     `json:"register_name_string"`
+	TargetQBit         uint       `json:"target_qbit"`
+	ControlQBits       []uint     `json:"control_qbits"`
+	SwapQBit           uint       `json:"swap_qbit"`
+	Options            []float64  `json:"options"`
+	GateName           string     `json:"gate_name,omitempty"`     // name of a user defined gate
+	TargetQBits        []uint     `json:"target_qbits,omitempty"`  // all target qbits of a user defined gate, TargetQBit is the first
+	ClassicalBit       uint       `json:"classical_bit,omitempty"` // global classical bit which a read writes
+	Condition          *Condition `json:"condition,omitempty"`     // classical condition of the operation
+	Axis               string     `json:"axis,omitempty"`          // axis "X", "Y" or "Z" of a rotate operation, kept when the angle is 0
 }
 
 type DumpFormat struct {
-	Message    string               `json:"message"`
-	Operations []Operation          `json:"operations"`
-	Registers  []DumpFormatRegister `json:"registers"`
-	QBits      [][]float64          `json:"qbits"`
+	Message            string                        `json:"message"`
+	Operations         []Operation                   `json:"operations"`
+	Registers          []DumpFormatRegister          `json:"registers"`
+	QBits              [][]float64                   `json:"qbits"`
+	Gates              []DumpFormatGate              `json:"gates,omitempty"` // definitions of the user defined gates in Operations
+	ClassicalRegisters []DumpFormatClassicalRegister `json:"classical_registers,omitempty"`
 }
 type DumpFormatRegister struct {
 	NumberOfQBits int    `json:"number_of_qbits"`
@@ -110,19 +138,29 @@ func refBit(val int, i uint) int {
 Assign qbits for the register
 
 num: The number of qbits which you want to assign
+
+If there are not enough free qbits, the register has no qbits and the error is kept for Err,
+see AllocateQBits.
 */
 func (q *QBitsCircuit) AssignQBits(num int, name string) *Register {
-	var qbits uint = 0
-	var shift = int(q.QBitNumber) - q.qBitsQueue.Size()
-	cnt := 0
+	reg, err := q.AllocateQBits(num, name)
+	if err != nil {
+		if q.err == nil {
+			q.err = err
+		}
+		return &Register{shift: int(q.QBitNumber), circuit: q, Name: name}
+	}
+	return reg
+}
+
+func (q *QBitsCircuit) assignQBits(num int, name string) *Register {
+	var qbitList []uint
 	for i := 0; i < num; i++ {
-		qbitIndex := q.qBitsQueue.Dequeue()
-		qbits = qbits | uint(qbitIndex)
-		cnt++
+		qbitList = append(qbitList, uint(q.qBitsQueue.Dequeue()))
 	}
-	reg := Register{numberOfQBits: cnt, qBits: qbits, shift: shift, circuit: q, Name: name}
-	q.qBitRegisters = append(q.qBitRegisters, &reg)
-	return &reg
+	reg := q.newRegister(qbitList, name)
+	q.qBitRegisters = append(q.qBitRegisters, reg)
+	return reg
 }
 
 /*
@@ -259,8 +297,13 @@ func (q *QBitsCircuit) GetQBitPairs(targetQBit uint) [][]uint {
 
 /*
 Read all qbits and return value in this circuit
+
+Inside CIf whose condition does not hold, nothing is read or recorded and 0 is returned.
 */
 func (q *QBitsCircuit) Read() int {
+	if !q.conditionHolds() {
+		return 0
+	}
 	ret := 0
 	var i uint
 	for i = 0; i < q.QBitNumber; i++ {
@@ -276,8 +319,13 @@ func (q *QBitsCircuit) Read() int {
 
 /*
 Read qbits specified by val and return val
+
+Inside CIf whose condition does not hold, nothing is read or recorded and 0 is returned.
 */
 func (q *QBitsCircuit) ReadQBits(val int) int {
+	if !q.conditionHolds() {
+		return 0
+	}
 	ret := 0
 	qbits := q.GetQBits(val)
 	for _, qbit := range qbits {
@@ -319,9 +367,25 @@ func (q *QBitsCircuit) Probability(targetIndex uint) (float64, float64) {
 }
 
 /*
-Read qbits specified by val and return val
+Use rnd for the random values of reads and measurements, e.g. a seeded source for reproducible runs.
+
+rnd: math/rand seeded by the time at every read when nil
+*/
+func (q *QBitsCircuit) SetRand(rnd *rand.Rand) {
+	q.rnd = rnd
+}
+
+/*
+Read one qbit and return 0 or 1, the qbit collapses to the read value.
+
+The collapse is a projective measurement: amplitudes of the other value are set to 0
+and those of the read value are renormalized, keeping their ratios and phases.
+It is not recorded, and returns 0 without reading while capturing or inside CIf whose condition does not hold.
 */
 func (q *QBitsCircuit) ReadQBit(targetIndex uint) uint {
+	if q.capturing > 0 || !q.conditionHolds() {
+		return 0
+	}
 
 	pairs := q.GetQBitPairs(targetIndex)
 
@@ -330,40 +394,34 @@ func (q *QBitsCircuit) ReadQBit(targetIndex uint) uint {
 
 	v0 = 0
 	v1 = 0
-	v0q := make(map[uint]int, 0)
-	v1q := make(map[uint]int, 0)
 	for _, pair := range pairs {
-		tmp0 := math.Pow(cmplx.Abs(q.RawQBits.At(pair[0])), 2)
-		tmp1 := math.Pow(cmplx.Abs(q.RawQBits.At(pair[1])), 2)
-		if tmp0 > 0 {
-			v0q[pair[0]]++
-		}
-		if tmp1 > 0 {
-			v1q[pair[1]]++
-		}
-		v0 += tmp0
-		v1 += tmp1
+		v0 += math.Pow(cmplx.Abs(q.RawQBits.At(pair[0])), 2)
+		v1 += math.Pow(cmplx.Abs(q.RawQBits.At(pair[1])), 2)
 	}
 
 	prob0 := v0 / (v0 + v1)
 
-	rand.Seed(time.Now().UnixNano())
+	var r float64
+	if q.rnd != nil {
+		r = q.rnd.Float64()
+	} else {
+		rand.Seed(time.Now().UnixNano())
+		r = rand.Float64()
+	}
 
+	// keep the amplitudes of the read value, so that later gates see the right state
 	var returnVal uint
-	if prob0 > rand.Float64() {
-
+	if prob0 > r {
+		scale := complex(1/math.Sqrt(v0), 0)
 		for _, pair := range pairs {
-			if _, ok := v0q[pair[0]]; ok {
-				q.RawQBits.Set(pair[0], cmplx.Sqrt(complex(1.0/float64(len(v0q)), 0)))
-			}
+			q.RawQBits.Set(pair[0], q.RawQBits.At(pair[0])*scale)
 			q.RawQBits.Set(pair[1], complex(0, 0))
 		}
 		returnVal = 0
 	} else {
+		scale := complex(1/math.Sqrt(v1), 0)
 		for _, pair := range pairs {
-			if _, ok := v1q[pair[1]]; ok {
-				q.RawQBits.Set(pair[1], cmplx.Sqrt(complex(1.0/float64(len(v1q)), 0)))
-			}
+			q.RawQBits.Set(pair[1], q.RawQBits.At(pair[1])*scale)
 			q.RawQBits.Set(pair[0], complex(0, 0))
 		}
 		returnVal = 1
@@ -373,8 +431,13 @@ func (q *QBitsCircuit) ReadQBit(targetIndex uint) uint {
 
 /*
 Write the val to qbits in this circuit
+
+Like gates, nothing is read or written while the circuit has an error, see Err.
 */
 func (q *QBitsCircuit) Write(val int) {
+	if q.err != nil {
+		return
+	}
 
 	readResult := 0
 	for _, qbit := range q.GetQBits(val) {
@@ -389,24 +452,37 @@ func (q *QBitsCircuit) Write(val int) {
 	}
 }
 
+/*
+Reset qbits specified by val to |0>.
+
+Every qbit is read and flipped when it is 1, so an entangled qbit leaves the other qbits
+in the state of the read value. Unlike Write, a reset is always recorded, one operation per qbit,
+and the qbit can be reused, e.g. as an ancilla, in the rest of the circuit.
+Like gates, nothing is read or reset while the circuit has an error, see Err.
+*/
+func (q *QBitsCircuit) Reset(val int) {
+	if q.err != nil {
+		return
+	}
+	for _, qbit := range q.GetQBits(val) {
+		if q.ReadQBit(qbit) == 1 {
+			q.NotWithoutOp(int(qbit), 0)
+		}
+		q.addOperation(OperationTypeReset, q.GetRegister(int(qbit)), int(qbit), 0, 0, nil)
+	}
+}
+
 /*
 Apply the unitary matrix to the vector of qbits
 */
 func (q *QBitsCircuit) Unitary(val int, controlValue int, m *mat.Matrix) {
+	if !q.validQBits(val, controlValue, 0) || q.capturing > 0 || !q.conditionHolds() {
+		return
+	}
 	targetQBits := q.GetQBits(val)
 
 	for _, targetQBit := range targetQBits {
-		pairs := q.GetQBitPairs(targetQBit)
-		for _, pair := range pairs {
-			if controlValue == 0 || int(pair[0])&controlValue == controlValue {
-				qbit := mat.NewVector(2)
-				qbit.Set(0, q.RawQBits.At(pair[0]))
-				qbit.Set(1, q.RawQBits.At(pair[1]))
-				newQBit := m.Dot(qbit)
-				q.RawQBits.Set(pair[0], newQBit.At(0))
-				q.RawQBits.Set(pair[1], newQBit.At(1))
-			}
-		}
+		applyMatrix(&q.RawQBits, targetQBit, controlValue, m)
 	}
 }
 
@@ -465,80 +541,62 @@ func (q *QBitsCircuit) NotWithoutOp(val int, controlValue int) {
 Rotate X gate
 */
 func (q *QBitsCircuit) RotX(val int, controlValue int, deg float64) {
-	q.rotImpl(val, controlValue, deg, 0, 0)
+	q.rotImpl(val, controlValue, 0, deg)
 }
 
 /*
 Rotate Y gate
 */
 func (q *QBitsCircuit) RotY(val int, controlValue int, deg float64) {
-	q.rotImpl(val, controlValue, 0, deg, 0)
+	q.rotImpl(val, controlValue, 1, deg)
 }
 
 /*
 Rotate X gate
 */
 func (q *QBitsCircuit) RotZ(val int, controlValue int, deg float64) {
-	q.rotImpl(val, controlValue, 0, 0, deg)
+	q.rotImpl(val, controlValue, 2, deg)
 }
 
 /*
 Rotate gate
-*/
-func (q *QBitsCircuit) rotImpl(val int, controlValue int, degX, degY, degZ float64) {
 
-	thetaX := degX * (math.Pi / 180.0)
-	thetaY := degY * (math.Pi / 180.0)
-	thetaZ := degZ * (math.Pi / 180.0)
+axis: 0 for X, 1 for Y and 2 for Z
+*/
+func (q *QBitsCircuit) rotImpl(val int, controlValue int, axis int, deg float64) {
+	options := rotateOptions(axis, deg)
 
-	var v00, v01, v10, v11 complex128
+	m := rotationMatrix(options[0], options[1], options[2])
 
-	v00 = 1
-	v01 = 0
-	v10 = 0
-	v11 = 1
+	q.Unitary(val, controlValue, &m)
 
-	if math.Abs(thetaX) > 0 {
-		v00 = complex(math.Cos(thetaX/2.0), 0)
-		v01 = complex(0, -math.Sin(thetaX/2.0))
-		v10 = complex(0, -math.Sin(thetaX/2.0))
-		v11 = complex(math.Cos(thetaX/2.0), 0)
+	n := len(q.operations)
+	q.addOperation(OperationTypeRotate, q.GetRegister(val), val, controlValue, 0, options)
+	for i := n; i < len(q.operations); i++ {
+		q.operations[i].Axis = rotationAxes[axis]
 	}
-	if math.Abs(thetaY) > 0 {
-		v00 = complex(math.Cos(thetaY/2.0), 0)
-		v01 = complex(-math.Sin(thetaY/2.0), 0)
-		v10 = complex(math.Sin(thetaY/2.0), 0)
-		v11 = complex(math.Cos(thetaY/2.0), 0)
-	}
-	if math.Abs(thetaZ) > 0 {
-		v00 = cmplx.Exp(complex(0, -thetaZ/2.0))
-		v01 = complex(0, 0)
-		v10 = complex(0, 0)
-		v11 = cmplx.Exp(complex(0, thetaZ/2.0))
-	}
-
-	m := mat.NewMatrix(2, 2)
-	m.Set(0, 0, v00)
-	m.Set(0, 1, v01)
-	m.Set(1, 0, v10)
-	m.Set(1, 1, v11)
+}
 
-	q.Unitary(val, controlValue, &m)
+/*
+Names of the rotation axes, indexed by 0 for X, 1 for Y and 2 for Z.
+*/
+var rotationAxes = []string{"X", "Y", "Z"}
 
-	q.addOperation(OperationTypeRotate, q.GetRegister(val), val, controlValue, 0, []float64{degX, degY, degZ})
+/*
+Return the options of a rotate operation about the axis (0 for X, 1 for Y and 2 for Z),
+the degrees about X, Y and Z.
+*/
+func rotateOptions(axis int, deg float64) []float64 {
+	options := []float64{0, 0, 0}
+	options[axis] = deg
+	return options
 }
 
 /*
 Phase Gate
 */
 func (q *QBitsCircuit) Phase(val int, controlValue int, deg float64) {
-	thetaZ := deg * (math.Pi / 180.0)
-
-	m := mat.NewMatrix(2, 2)
-	m.Set(0, 0, 1)
-	m.Set(0, 1, 0)
-	m.Set(1, 0, 0)
-	m.Set(1, 1, cmplx.Exp(complex(0, thetaZ)))
+	m := phaseMatrix(deg)
 
 	q.Unitary(val, controlValue, &m)
 
@@ -590,10 +648,41 @@ func (q *QBitsCircuit) Z(val int, controlValue int) {
 	q.addOperation(OperationTypeZ, q.GetRegister(val), val, controlValue, 0, nil)
 }
 
+/*
+SX Gate, the square root of X
+
+[[(1+i)/2, (1-i)/2], [(1-i)/2, (1+i)/2]]
+*/
+func (q *QBitsCircuit) SX(val int, controlValue int) {
+	m := sxMatrix()
+
+	q.Unitary(val, controlValue, &m)
+
+	q.addOperation(OperationTypeSX, q.GetRegister(val), val, controlValue, 0, nil)
+}
+
+/*
+U3 Gate, the general single qbit gate
+
+[[cos(theta/2), -e^(i lambda) sin(theta/2)], [e^(i phi) sin(theta/2), e^(i (phi+lambda)) cos(theta/2)]]
+
+theta, phi, lambda: degree
+*/
+func (q *QBitsCircuit) U3(val int, controlValue int, theta, phi, lambda float64) {
+	m := u3Matrix(theta, phi, lambda)
+
+	q.Unitary(val, controlValue, &m)
+
+	q.addOperation(OperationTypeU3, q.GetRegister(val), val, controlValue, 0, []float64{theta, phi, lambda})
+}
+
 /*
 Swap gate
 */
 func (q *QBitsCircuit) Swap(targetVal int, swapVal int, controlValue int) {
+	if !q.validQBits(targetVal, controlValue, swapVal) {
+		return
+	}
 
 	newControlValue := controlValue | swapVal
 	q.NotWithoutOp(targetVal, newControlValue)
@@ -611,8 +700,14 @@ func (q *QBitsCircuit) Swap(targetVal int, swapVal int, controlValue int) {
 Shift left
 */
 func (q *QBitsCircuit) ShiftLeft(targetVal, controlVal int, shift int) {
-	targetQBits := q.GetQBits(targetVal)
+	q.shiftLeft(q.GetQBits(targetVal), controlVal, shift)
+}
 
+func (q *QBitsCircuit) shiftLeft(targetQBits []uint, controlVal int, shift int) {
+	// no shift would swap every qbit with itself
+	if shift <= 0 {
+		return
+	}
 	tl := len(targetQBits)
 
 	for i := 0; i < tl-1; i++ {
@@ -632,8 +727,13 @@ QFT
 Quantum version of Discrete Fourier transform(DFT)
 */
 func (q *QBitsCircuit) QFT(val int) {
-	idxs := q.GetQBits(val)
+	q.qft(q.GetQBits(val))
+}
 
+/*
+QFT of qbits in the order from the lowest digit
+*/
+func (q *QBitsCircuit) qft(idxs []uint) {
 	for j := len(idxs) - 1; j >= 0; j-- {
 		highestQbit := idxs[j]
 		q.Had(int(highestQbit), 0)
@@ -643,7 +743,8 @@ func (q *QBitsCircuit) QFT(val int) {
 			deg = deg / 2.0
 		}
 	}
-	for j := len(idxs) - 1; j >= len(idxs)/2; j-- {
+	// the middle qbit of an odd number of qbits stays
+	for j := len(idxs) - 1; j >= (len(idxs)+1)/2; j-- {
 		lowestQbit := idxs[len(idxs)-1-j]
 		highestQbit := idxs[j]
 		q.Swap(int(highestQbit), int(lowestQbit), 0)
@@ -654,9 +755,15 @@ func (q *QBitsCircuit) QFT(val int) {
 Inversed QFT
 */
 func (q *QBitsCircuit) InversedQFT(val int) {
-	idxs := q.GetQBits(val)
+	q.inversedQFT(q.GetQBits(val))
+}
 
-	for j := len(idxs) - 1; j >= len(idxs)/2; j-- {
+/*
+Inversed QFT of qbits in the order from the lowest digit
+*/
+func (q *QBitsCircuit) inversedQFT(idxs []uint) {
+	// the middle qbit of an odd number of qbits stays
+	for j := len(idxs) - 1; j >= (len(idxs)+1)/2; j-- {
 		lowestQbit := idxs[len(idxs)-1-j]
 		highestQbit := idxs[j]
 		q.Swap(int(highestQbit), int(lowestQbit), 0)
@@ -732,6 +839,31 @@ func (q *QBitsCircuit) Add(rangeValue int, val int, controlVal int) {
 	}
 
 }
+/*
+Add 1 to the qbits in the order from the lowest digit.
+*/
+func (q *QBitsCircuit) incrementQBits(qbits []uint, controlValue int) {
+	newControlVal := controlValue
+	for _, qb := range qbits {
+		newControlVal |= int(qb)
+	}
+	for i := len(qbits) - 1; i >= 0; i-- {
+		newControlVal ^= int(qbits[i])
+		q.Not(int(qbits[i]), newControlVal)
+	}
+}
+
+/*
+Subtract 1 from the qbits in the order from the lowest digit.
+*/
+func (q *QBitsCircuit) decrementQBits(qbits []uint, controlValue int) {
+	newControlVal := controlValue
+	for _, qb := range qbits {
+		q.Not(int(qb), newControlVal)
+		newControlVal |= int(qb)
+	}
+}
+
 func (q *QBitsCircuit) addImpl(rangeValue int, controlValue int) {
 	cidxes := q.GetQBits(rangeValue)
 	newControlVal := rangeValue | controlValue
@@ -782,19 +914,30 @@ func (q *QBitsCircuit) subtractImpl(controlVal int, controlValue int) {
 }
 
 func (q *QBitsCircuit) addOperation(opName string, reg *Register, target int, control int, swap int, options []float64) {
+	if opName == OperationTypeSpace {
+		op := Operation{OpName: opName, TargetQBit: 0, ControlQBits: nil, SwapQBit: uint(swap), Options: options}
+		if reg != nil {
+			op.RegisterName = 1 << reg.shift
+			op.RegisterNameString = reg.Name
+		}
+		q.operations = append(q.operations, op)
+		return
+	}
+	if !q.validQBits(target, control, swap) {
+		return
+	}
 	controls := q.GetQBits(control)
 
 	for _, t := range q.GetQBits(target) {
+		// every target is recorded with its own register
+		reg := q.GetRegister(int(t))
 		var op Operation
 		if len(controls) > 0 {
 			op = Operation{OpName: opName, RegisterName: 1 << reg.shift, RegisterNameString: reg.Name, TargetQBit: t, ControlQBits: controls, SwapQBit: uint(swap), Options: options}
 		} else {
 			op = Operation{OpName: opName, RegisterName: 1 << reg.shift, RegisterNameString: reg.Name, TargetQBit: t, ControlQBits: nil, SwapQBit: uint(swap), Options: options}
 		}
-		q.operations = append(q.operations, op)
-	}
-	if opName == OperationTypeSpace {
-		op := Operation{OpName: opName, RegisterName: 1 << reg.shift, RegisterNameString: reg.Name, TargetQBit: 0, ControlQBits: nil, SwapQBit: uint(swap), Options: options}
+		op.Condition = q.condition
 		q.operations = append(q.operations, op)
 	}
 }
@@ -843,7 +986,7 @@ func (q QBitsCircuit) DumpAll() string {
 		newReg := DumpFormatRegister{}
 		newReg.Shift = reg.shift
 		newReg.NumberOfQBits = reg.numberOfQBits
-		newReg.QBits = q.GetQBits(int(reg.GetQBits()))
+		newReg.QBits = append([]uint{}, reg.qbitList...)
 		if reg.Name != "" {
 			newReg.Name = reg.Name
 		} else {
@@ -852,7 +995,8 @@ func (q QBitsCircuit) DumpAll() string {
 		registers = append(registers, newReg)
 	}
 
-	df := DumpFormat{Message: msg, Operations: ops, Registers: registers, QBits: qbits}
+	df := DumpFormat{Message: msg, Operations: ops, Registers: registers, QBits: qbits, Gates: q.GateRegistry().dumpGates(ops),
+		ClassicalRegisters: q.dumpClassicalRegisters()}
 
 	r, _ := json.Marshal(df)
 	out := new(bytes.Buffer)
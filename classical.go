@@ -0,0 +1,332 @@
+package goqkit
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+/*
+Classical bits of a circuit which hold measurement results.
+
+Like qbits, classical bits are addressed by global values (1<<i for the i-th classical bit of the circuit)
+or by local values of a register.
+*/
+type ClassicalRegister struct {
+	Name         string
+	numberOfBits int
+	bits         uint
+	shift        int
+	circuit      *QBitsCircuit
+}
+
+/*
+Condition of an operation on the value of a classical register, see CIf.
+*/
+type Condition struct {
+	//Name of the classical register
+	Register string `json:"register"`
+	//Global value of the classical bits of the register
+	Bits uint `json:"bits"`
+	//Value of the register for which the operation is applied
+	Value int `json:"value"`
+}
+
+/*
+Classical register in a JSON dump.
+*/
+type DumpFormatClassicalRegister struct {
+	NumberOfBits int    `json:"number_of_bits"`
+	Bits         uint   `json:"bits"`
+	Shift        int    `json:"shift"`
+	Name         string `json:"reg_name"`
+	Value        int    `json:"value"`
+}
+
+/*
+Assign classical bits for a register. All bits start at 0.
+
+num: The number of classical bits which you want to assign
+
+name: name of the register, "CReg1", "CReg2", ... if empty
+*/
+func (q *QBitsCircuit) AssignClassicalBits(num int, name string) *ClassicalRegister {
+	if name == "" {
+		name = fmt.Sprintf("CReg%d", len(q.classicalRegisters)+1)
+	}
+	shift := 0
+	for _, creg := range q.classicalRegisters {
+		shift += creg.numberOfBits
+	}
+	creg := ClassicalRegister{Name: name, numberOfBits: num, bits: ((1 << uint(num)) - 1) << uint(shift), shift: shift, circuit: q}
+	q.classicalRegisters = append(q.classicalRegisters, &creg)
+	return &creg
+}
+
+/*
+Return the values of all classical bits as a global value.
+*/
+func (q *QBitsCircuit) ClassicalBits() uint {
+	return q.classicalBits
+}
+
+/*
+Return the classical register which has the classical bits of val.
+*/
+func (q *QBitsCircuit) GetClassicalRegister(val uint) *ClassicalRegister {
+	for _, creg := range q.classicalRegisters {
+		if creg.bits&val != 0 {
+			return creg
+		}
+	}
+	return nil
+}
+
+/*
+Return the number of classical bits.
+*/
+func (c *ClassicalRegister) NumberOfBits() int {
+	return c.numberOfBits
+}
+
+/*
+Return the global value of all classical bits of this register.
+*/
+func (c *ClassicalRegister) GetBits() uint {
+	return c.bits
+}
+
+/*
+Convert a local classical bits value of this register to the global value.
+*/
+func (c *ClassicalRegister) ToGlobalBits(val int) uint {
+	return uint(val) << uint(c.shift) & c.bits
+}
+
+/*
+Return the value of this register.
+*/
+func (c *ClassicalRegister) Value() int {
+	return int(c.circuit.classicalBits&c.bits) >> uint(c.shift)
+}
+
+/*
+Return the value (0 or 1) of the i-th bit of this register.
+*/
+func (c *ClassicalRegister) Bit(i int) int {
+	return (c.Value() >> uint(i)) & 1
+}
+
+/*
+Read qbits into classical bits. The i-th lowest qbit is written to the i-th lowest classical bit.
+
+Every read is recorded as a read operation with its classical bit,
+and inside CIf it only happens when the condition holds.
+
+val: global qbits value
+
+cbits: global classical bits value with as many bits as val
+
+Return the read value as a global qbits value.
+*/
+func (q *QBitsCircuit) Measure(val int, cbits uint) (int, error) {
+	if err := q.ValidateQBits(val, 0); err != nil {
+		return 0, err
+	}
+	return q.measureList(q.GetQBits(val), cbits)
+}
+
+/*
+Read the qbits in order into the classical bits from the lowest one.
+*/
+func (q *QBitsCircuit) measureList(qbits []uint, cbits uint) (int, error) {
+	var targets []uint
+	for b := cbits; b != 0; b &= b - 1 {
+		targets = append(targets, b&-b)
+	}
+	if len(qbits) != len(targets) {
+		return 0, fmt.Errorf("measure %d qbits into %d classical bits", len(qbits), len(targets))
+	}
+	for _, t := range targets {
+		if q.GetClassicalRegister(t) == nil {
+			return 0, fmt.Errorf("classical bit %d is not assigned", bits.TrailingZeros(t))
+		}
+	}
+
+	ret := 0
+	for i, qbit := range qbits {
+		r := q.measureQBit(qbit, targets[i])
+		ret |= int(r) * int(qbit)
+	}
+	return ret, nil
+}
+
+/*
+Read one qbit into a classical bit and record it.
+*/
+func (q *QBitsCircuit) measureQBit(qbit uint, cbit uint) uint {
+	r := uint(0)
+	if q.classicalBits&cbit != 0 {
+		r = 1
+	}
+	if q.capturing == 0 && q.conditionHolds() {
+		r = q.ReadQBit(qbit)
+		if r == 1 {
+			q.classicalBits |= cbit
+		} else {
+			q.classicalBits &^= cbit
+		}
+	}
+	q.addOperation(OperationTypeRead, q.GetRegister(int(qbit)), int(qbit), 0, 0, []float64{float64(r)})
+	q.operations[len(q.operations)-1].ClassicalBit = cbit
+	return r
+}
+
+/*
+Read qbits of this register into classical bits of a classical register.
+
+val: local qbits value
+
+creg: classical register
+
+cval: local classical bits value of creg
+*/
+func (reg *Register) Measure(val int, creg *ClassicalRegister, cval int) (int, error) {
+	global := reg.ToGlobalQBits(val)
+	if err := reg.circuit.ValidateQBits(global, 0); err != nil {
+		return 0, err
+	}
+	r, err := reg.circuit.measureList(reg.localQBitList(val), creg.ToGlobalBits(cval))
+	return reg.ToLocalQBits(r), err
+}
+
+/*
+Read all qbits of this register into a classical register of the same size.
+*/
+func (reg *Register) MeasureAll(creg *ClassicalRegister) (int, error) {
+	return reg.Measure((1<<uint(reg.numberOfQBits))-1, creg, (1<<uint(creg.numberOfBits))-1)
+}
+
+/*
+Apply the gates which f calls only if the classical register has the value, like c_if of OpenQASM.
+
+The gates are recorded with the condition, so that the circuit can be replayed and dumped.
+CIf can not be nested.
+
+creg: classical register of the condition
+
+value: local value of creg
+*/
+func (q *QBitsCircuit) CIf(creg *ClassicalRegister, value int, f func()) error {
+	if q.condition != nil {
+		return fmt.Errorf("CIf can not be nested")
+	}
+	q.condition = &Condition{Register: creg.Name, Bits: creg.bits, Value: value}
+	defer func() {
+		q.condition = nil
+	}()
+	f()
+	return nil
+}
+
+/*
+Return true if the condition holds for the global classical bits value.
+*/
+func (c *Condition) Holds(classicalBits uint) bool {
+	return int(classicalBits&c.Bits)>>uint(bits.TrailingZeros(c.Bits)) == c.Value
+}
+
+/*
+Return true if there is no condition, or the condition of CIf holds now.
+*/
+func (q *QBitsCircuit) conditionHolds() bool {
+	return q.condition == nil || q.condition.Holds(q.classicalBits)
+}
+
+func (q *QBitsCircuit) dumpClassicalRegisters() []DumpFormatClassicalRegister {
+	var cregs []DumpFormatClassicalRegister
+	for _, creg := range q.classicalRegisters {
+		cregs = append(cregs, DumpFormatClassicalRegister{NumberOfBits: creg.numberOfBits, Bits: creg.bits,
+			Shift: creg.shift, Name: creg.Name, Value: creg.Value()})
+	}
+	return cregs
+}
+
+/*
+Run recorded operations on this circuit again: gates change the qbits,
+reads with a classical bit measure into it, and conditional operations are only applied
+when their condition holds for the current classical bits.
+
+All operations are recorded again, reads with the new read values.
+Nothing is replayed if the circuit already has an error, which is returned, see Err.
+*/
+func (q *QBitsCircuit) Replay(ops []Operation) error {
+	if q.err != nil {
+		return q.err
+	}
+	for k, op := range ops {
+		err := q.replayOperation(op)
+		if err == nil {
+			err = q.err
+		}
+		if err != nil {
+			return fmt.Errorf("operation %d: %v", k, err)
+		}
+	}
+	return nil
+}
+
+func (q *QBitsCircuit) replayOperation(op Operation) error {
+	q.condition = op.Condition
+	defer func() {
+		q.condition = nil
+	}()
+
+	switch op.OpName {
+	case OperationTypeRead:
+		if op.ClassicalBit != 0 {
+			if q.GetClassicalRegister(op.ClassicalBit) == nil {
+				return fmt.Errorf("classical bit %d is not assigned", bits.TrailingZeros(op.ClassicalBit))
+			}
+			q.measureQBit(op.TargetQBit, op.ClassicalBit)
+			return nil
+		}
+		q.ReadQBits(int(op.TargetQBit))
+		return nil
+	case OperationTypeWrite:
+		q.Write(int(op.TargetQBit))
+		return nil
+	case OperationTypeReset:
+		q.Reset(int(op.TargetQBit))
+		return nil
+	}
+	return q.applyRecorded(op)
+}
+
+/*
+Make a circuit with the qbits, registers and classical registers of a dump, e.g. to replay its operations.
+
+The qbits are |0> and the classical bits 0.
+*/
+func CircuitFromDump(df DumpFormat) (*QBitsCircuit, error) {
+	n := 0
+	for 1<<uint(n) < len(df.QBits) {
+		n++
+	}
+	if 1<<uint(n) != len(df.QBits) {
+		return nil, fmt.Errorf("dump has %d amplitudes, not a power of 2", len(df.QBits))
+	}
+	c := MakeQBitsCircuit(n)
+	q := &c
+	for _, r := range df.Registers {
+		if _, err := q.assignQBitList(r.QBits, r.Name); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range df.ClassicalRegisters {
+		creg := q.AssignClassicalBits(r.NumberOfBits, r.Name)
+		if creg.bits != r.Bits {
+			return nil, fmt.Errorf("classical register %q has bits %b instead of %b", r.Name, creg.bits, r.Bits)
+		}
+	}
+	return q, nil
+}
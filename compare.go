@@ -0,0 +1,91 @@
+package goqkit
+
+import (
+	"github.com/takezo5096/goqkit/mat"
+	"math"
+	"math/cmplx"
+)
+
+/*
+Return the fidelity |<a|b>|^2 between two pure states.
+
+The states are normalized before comparing.
+*/
+func Fidelity(a, b mat.Vector) float64 {
+	ab := innerProduct(a, b)
+	na := real(innerProduct(a, a))
+	nb := real(innerProduct(b, b))
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return (real(ab)*real(ab) + imag(ab)*imag(ab)) / (na * nb)
+}
+
+/*
+Return the trace distance between two pure states, sqrt(1 - fidelity).
+*/
+func TraceDistance(a, b mat.Vector) float64 {
+	return math.Sqrt(math.Max(0, 1-Fidelity(a, b)))
+}
+
+/*
+Return the Uhlmann fidelity (Tr sqrt(sqrt(rho) sigma sqrt(rho)))^2 between two density matrices.
+*/
+func DensityFidelity(rho, sigma mat.Matrix) float64 {
+	sqrtRho := hermitianSqrt(&rho)
+	tmp := sqrtRho.Mul(&sigma)
+	m := tmp.Mul(&sqrtRho)
+	values, _ := m.EigenHermitian()
+	f := 0.0
+	for _, v := range values {
+		f += math.Sqrt(math.Max(v, 0))
+	}
+	return f * f
+}
+
+/*
+Return the trace distance 1/2 Tr|rho - sigma| between two density matrices.
+*/
+func DensityTraceDistance(rho, sigma mat.Matrix) float64 {
+	d := mat.NewMatrix(rho.Rows, rho.Cols)
+	var i, j uint
+	for i = 0; i < rho.Rows; i++ {
+		for j = 0; j < rho.Cols; j++ {
+			d.Set(i, j, rho.At(i, j)-sigma.At(i, j))
+		}
+	}
+	values, _ := d.EigenHermitian()
+	t := 0.0
+	for _, v := range values {
+		t += math.Abs(v)
+	}
+	return t / 2
+}
+
+/*
+Return true if a and b are the same state except for a global phase.
+
+Every amplitude of b must be within tol of the corresponding amplitude of a multiplied by the phase.
+*/
+func EqualUpToGlobalPhase(a, b mat.Vector, tol float64) bool {
+	if a.N != b.N {
+		return false
+	}
+	phase := complex(1, 0)
+	if ab := innerProduct(a, b); cmplx.Abs(ab) > 0 {
+		phase = ab / complex(cmplx.Abs(ab), 0)
+	}
+	for i := range a.Data {
+		if cmplx.Abs(a.Data[i]*phase-b.Data[i]) > tol {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Return the fidelity between the current qbits and the expected state.
+*/
+func (q *QBitsCircuit) Fidelity(expected mat.Vector) float64 {
+	return Fidelity(q.RawQBits, expected)
+}
@@ -0,0 +1,340 @@
+package goqkit
+
+import (
+	"sort"
+)
+
+/*
+How an operation acts on one of its qbits.
+
+Two operations commute on a qbit if both act as the same Pauli basis (Z, X or Y),
+e.g. a control and a phase gate (both diagonal), or two controlled Nots with the same target.
+*/
+type qbitAction int
+
+const (
+	qbitActionOther qbitAction = iota
+	qbitActionZ
+	qbitActionX
+	qbitActionY
+)
+
+/*
+Wire of the DAG, a qbit or a classical bit by its global value.
+*/
+type dagWire struct {
+	bit       uint
+	classical bool
+}
+
+/*
+Return how the operation acts on each of its wires.
+
+Space operations act on all n qbits as a barrier.
+Classical bits are added as classical wires, see addClassicalActions.
+*/
+func qbitActions(n uint, op Operation) map[dagWire]qbitAction {
+	actions := make(map[dagWire]qbitAction)
+	if op.OpName == OperationTypeSpace {
+		var i uint
+		for i = 0; i < n; i++ {
+			actions[dagWire{bit: 1 << i}] = qbitActionOther
+		}
+		return actions
+	}
+
+	for _, c := range op.ControlQBits {
+		actions[dagWire{bit: c}] = qbitActionZ
+	}
+	var target qbitAction = qbitActionOther
+	switch op.OpName {
+	case OperationTypeNot, OperationTypeX, OperationTypeSX:
+		target = qbitActionX
+	case OperationTypeY:
+		target = qbitActionY
+	case OperationTypeZ, OperationTypePhase:
+		target = qbitActionZ
+	case OperationTypeRotate:
+		switch axis, _ := rotationAxis(op); axis {
+		case "X":
+			target = qbitActionX
+		case "Y":
+			target = qbitActionY
+		default:
+			target = qbitActionZ
+		}
+	}
+	actions[dagWire{bit: op.TargetQBit}] = target
+	for _, qb := range op.TargetQBits {
+		actions[dagWire{bit: qb}] = qbitActionOther
+	}
+	if op.OpName == OperationTypeSwap && op.SwapQBit != 0 {
+		actions[dagWire{bit: op.SwapQBit}] = qbitActionOther
+	}
+	addClassicalActions(actions, op)
+	return actions
+}
+
+func actionsCommute(a, b qbitAction) bool {
+	return a == b && a != qbitActionOther
+}
+
+/*
+Add the classical bits which a read writes (Other) and a condition reads (Z) to the actions.
+
+Reads into the same classical bit stay in order and conditional gates are ordered after the read
+which sets their condition, while conditional gates on the same bits commute.
+*/
+func addClassicalActions(actions map[dagWire]qbitAction, op Operation) {
+	if op.OpName == OperationTypeRead && op.ClassicalBit != 0 {
+		actions[dagWire{bit: op.ClassicalBit, classical: true}] = qbitActionOther
+	}
+	if op.Condition != nil {
+		for _, b := range qbitList(int(op.Condition.Bits)) {
+			actions[dagWire{bit: b, classical: true}] = qbitActionZ
+		}
+	}
+}
+
+/*
+Return true if the two operations commute.
+
+This is a sufficient check: operations on disjoint qbits commute,
+and so do operations which act in the same Pauli basis on every shared qbit
+(diagonal gates through controls, controlled Nots sharing a target, and so on).
+*/
+func Commute(a, b Operation) bool {
+	if a.OpName == OperationTypeSpace || b.OpName == OperationTypeSpace {
+		return false
+	}
+	aa := qbitActions(0, a)
+	ba := qbitActions(0, b)
+	for w, act := range aa {
+		if other, ok := ba[w]; ok && !actionsCommute(act, other) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Dependency between two operations of a DAG on a qbit or a classical bit.
+*/
+type DAGEdge struct {
+	From int
+	To   int
+	//Global qbit value, or global classical bit value if Classical
+	QBit uint
+	//The dependency is through a classical bit
+	Classical bool
+}
+
+/*
+Dependency graph of operations.
+
+Nodes are identified by their index in the operation list.
+An edge is only made between operations which share a qbit and do not commute on it,
+so any topological order of the DAG implements the same circuit.
+*/
+type DAG struct {
+	n          uint
+	operations []Operation
+	preds      [][]DAGEdge
+	succs      [][]DAGEdge
+}
+
+type dagHistory struct {
+	node   int
+	action qbitAction
+}
+
+/*
+Build the dependency graph of operations on n qbits.
+*/
+func NewDAG(n uint, ops []Operation) *DAG {
+	d := &DAG{n: n, operations: ops, preds: make([][]DAGEdge, len(ops)), succs: make([][]DAGEdge, len(ops))}
+
+	// operations on each qbit so far
+	history := make(map[dagWire][]dagHistory)
+
+	for j, op := range ops {
+		for w, act := range qbitActions(n, op) {
+			h := history[w]
+			// the nearest run of operations which do not commute with op are its predecessors,
+			// older ones are already ordered before that run.
+			var collected qbitAction = qbitActionOther
+			found := false
+			for k := len(h) - 1; k >= 0; k-- {
+				if !found {
+					if actionsCommute(act, h[k].action) {
+						continue
+					}
+					found = true
+					collected = h[k].action
+					d.addEdge(h[k].node, j, w)
+					if collected == qbitActionOther {
+						break
+					}
+					continue
+				}
+				if h[k].action != collected {
+					break
+				}
+				d.addEdge(h[k].node, j, w)
+			}
+			history[w] = append(h, dagHistory{node: j, action: act})
+		}
+	}
+	for j := range d.preds {
+		sortEdges(d.preds[j])
+		sortEdges(d.succs[j])
+	}
+	return d
+}
+
+func sortEdges(edges []DAGEdge) {
+	sort.Slice(edges, func(a, b int) bool {
+		if edges[a].From != edges[b].From {
+			return edges[a].From < edges[b].From
+		}
+		if edges[a].To != edges[b].To {
+			return edges[a].To < edges[b].To
+		}
+		if edges[a].Classical != edges[b].Classical {
+			return !edges[a].Classical
+		}
+		return edges[a].QBit < edges[b].QBit
+	})
+}
+
+func (d *DAG) addEdge(from, to int, w dagWire) {
+	e := DAGEdge{From: from, To: to, QBit: w.bit, Classical: w.classical}
+	d.preds[to] = append(d.preds[to], e)
+	d.succs[from] = append(d.succs[from], e)
+}
+
+/*
+Return the number of nodes.
+*/
+func (d *DAG) Len() int {
+	return len(d.operations)
+}
+
+/*
+Return the operation of the node i.
+*/
+func (d *DAG) Operation(i int) Operation {
+	return d.operations[i]
+}
+
+/*
+Return all edges into the node i.
+*/
+func (d *DAG) InEdges(i int) []DAGEdge {
+	return d.preds[i]
+}
+
+/*
+Return all edges out of the node i.
+*/
+func (d *DAG) OutEdges(i int) []DAGEdge {
+	return d.succs[i]
+}
+
+/*
+Return the nodes which the node i directly depends on.
+*/
+func (d *DAG) Predecessors(i int) []int {
+	return uniqueNodes(d.preds[i], true, 0)
+}
+
+/*
+Return the nodes which directly depend on the node i.
+*/
+func (d *DAG) Successors(i int) []int {
+	return uniqueNodes(d.succs[i], false, 0)
+}
+
+/*
+Return the nodes which the node i directly depends on through the qbit.
+
+qbit: global qbit value (only one bit)
+*/
+func (d *DAG) PredecessorsOn(i int, qbit uint) []int {
+	return uniqueNodes(d.preds[i], true, qbit)
+}
+
+/*
+Return the nodes which directly depend on the node i through the qbit.
+
+qbit: global qbit value (only one bit)
+*/
+func (d *DAG) SuccessorsOn(i int, qbit uint) []int {
+	return uniqueNodes(d.succs[i], false, qbit)
+}
+
+func uniqueNodes(edges []DAGEdge, from bool, qbit uint) []int {
+	seen := make(map[int]bool)
+	nodes := make([]int, 0)
+	for _, e := range edges {
+		if qbit != 0 && (e.QBit != qbit || e.Classical) {
+			continue
+		}
+		node := e.To
+		if from {
+			node = e.From
+		}
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Ints(nodes)
+	return nodes
+}
+
+/*
+Return the nodes grouped in layers.
+
+Each node is in the layer right after its latest predecessor,
+so nodes in the same layer do not depend on each other.
+*/
+func (d *DAG) Layers() [][]int {
+	level := make([]int, len(d.operations))
+	layers := make([][]int, 0)
+	// operations are already in a topological order
+	for j := range d.operations {
+		for _, e := range d.preds[j] {
+			if level[e.From]+1 > level[j] {
+				level[j] = level[e.From] + 1
+			}
+		}
+		for len(layers) <= level[j] {
+			layers = append(layers, []int{})
+		}
+		layers[level[j]] = append(layers[level[j]], j)
+	}
+	return layers
+}
+
+/*
+Return the nodes in a topological order, layer by layer.
+*/
+func (d *DAG) TopologicalOrder() []int {
+	order := make([]int, 0, len(d.operations))
+	for _, layer := range d.Layers() {
+		order = append(order, layer...)
+	}
+	return order
+}
+
+/*
+Return the operations in the order of TopologicalOrder.
+*/
+func (d *DAG) Operations() []Operation {
+	ops := make([]Operation, 0, len(d.operations))
+	for _, i := range d.TopologicalOrder() {
+		ops = append(ops, d.operations[i])
+	}
+	return ops
+}
@@ -0,0 +1,335 @@
+package goqkit
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Return the index of a qbit from its global value (only one bit).
+
+Example: 0x08 returns 3.
+*/
+func qbitIndex(qbit uint) int {
+	return bits.TrailingZeros(qbit)
+}
+
+/*
+Return all qbits which the operation acts on: target, controls and swap qbit.
+
+A space operation acts on no qbits.
+*/
+func (op Operation) QBits() []uint {
+	if op.OpName == OperationTypeSpace {
+		return nil
+	}
+	qbits := []uint{op.TargetQBit}
+	if op.OpName == OperationTypeGate && len(op.TargetQBits) > 0 {
+		qbits = append([]uint{}, op.TargetQBits...)
+	}
+	qbits = append(qbits, op.ControlQBits...)
+	if op.OpName == OperationTypeSwap && op.SwapQBit != 0 {
+		qbits = append(qbits, op.SwapQBit)
+	}
+	return qbits
+}
+
+/*
+Pack operations into columns for drawing.
+
+An operation occupies all qbit lines from its lowest to its highest qbit,
+and is put in the first column after every earlier operation which overlaps it.
+A space operation closes all columns before it.
+*/
+func layerOperations(n uint, ops []Operation) [][]int {
+	layers := make([][]int, 0)
+	// next free column of each qbit line
+	next := make([]int, n)
+	// next free column of each classical bit, so that conditional gates are drawn after their reads
+	nextClassical := make(map[uint]int)
+	for k, op := range ops {
+		lo, hi := 0, int(n)-1
+		if op.OpName != OperationTypeSpace {
+			lo, hi = operationSpan(op)
+		}
+		col := 0
+		for i := lo; i <= hi; i++ {
+			if next[i] > col {
+				col = next[i]
+			}
+		}
+		cbits := classicalBitsOf(op)
+		for _, b := range cbits {
+			if nextClassical[b] > col {
+				col = nextClassical[b]
+			}
+		}
+		if op.OpName == OperationTypeSpace {
+			col = len(layers)
+		}
+		for len(layers) <= col {
+			layers = append(layers, []int{})
+		}
+		layers[col] = append(layers[col], k)
+		for i := lo; i <= hi; i++ {
+			next[i] = col + 1
+		}
+		for _, b := range cbits {
+			nextClassical[b] = col + 1
+		}
+		if op.OpName == OperationTypeSpace {
+			for i := range next {
+				next[i] = col + 1
+			}
+		}
+	}
+	return layers
+}
+
+/*
+Return the single classical bit values which the operation reads into or depends on.
+*/
+func classicalBitsOf(op Operation) []uint {
+	var cbits []uint
+	if op.OpName == OperationTypeRead && op.ClassicalBit != 0 {
+		cbits = append(cbits, op.ClassicalBit)
+	}
+	if op.Condition != nil {
+		cbits = append(cbits, qbitList(int(op.Condition.Bits))...)
+	}
+	return cbits
+}
+
+/*
+Return the lowest and highest qbit index which the operation acts on.
+*/
+func operationSpan(op Operation) (int, int) {
+	lo, hi := -1, -1
+	for _, qb := range op.QBits() {
+		i := qbitIndex(qb)
+		if lo < 0 || i < lo {
+			lo = i
+		}
+		if i > hi {
+			hi = i
+		}
+	}
+	return lo, hi
+}
+
+/*
+Return the gate name of an operation for diagrams, e.g. "H", "RY(90)" or "P(45)".
+
+A conditional gate has its condition appended, e.g. "X[c=1]".
+*/
+func operationLabel(op Operation) string {
+	if op.Condition != nil {
+		plain := op
+		plain.Condition = nil
+		return fmt.Sprintf("%s[%s=%d]", operationLabel(plain), op.Condition.Register, op.Condition.Value)
+	}
+	switch op.OpName {
+	case OperationTypeNot:
+		return "X"
+	case OperationTypeRotate:
+		axis, deg := rotationAxis(op)
+		return fmt.Sprintf("R%s(%s)", axis, formatDegree(deg))
+	case OperationTypePhase:
+		return fmt.Sprintf("P(%s)", formatDegree(optionAt(op, 0)))
+	case OperationTypeU3:
+		return fmt.Sprintf("U3(%s,%s,%s)", formatDegree(optionAt(op, 0)), formatDegree(optionAt(op, 1)), formatDegree(optionAt(op, 2)))
+	case OperationTypeRead:
+		if len(op.Options) > 0 {
+			return fmt.Sprintf("M=%d", int(op.Options[0]))
+		}
+		return "M"
+	case OperationTypeReset:
+		return "|0>"
+	case OperationTypeGate:
+		return op.GateName
+	}
+	return op.OpName
+}
+
+/*
+Return the axis ("X", "Y" or "Z") and the angle in degrees of a rotate operation.
+*/
+func rotationAxis(op Operation) (string, float64) {
+	axis := rotationAxisIndex(op)
+	return rotationAxes[axis], optionAt(op, axis)
+}
+
+/*
+Return the axis of a rotate operation, 0 for X, 1 for Y and 2 for Z.
+
+Operations without the recorded axis, e.g. of older dumps, take the axis of the first angle which is not 0.
+*/
+func rotationAxisIndex(op Operation) int {
+	for axis, name := range rotationAxes {
+		if op.Axis == name {
+			return axis
+		}
+	}
+	switch {
+	case optionAt(op, 0) != 0:
+		return 0
+	case optionAt(op, 1) != 0:
+		return 1
+	}
+	return 2
+}
+
+func optionAt(op Operation, i int) float64 {
+	if i < len(op.Options) {
+		return op.Options[i]
+	}
+	return 0
+}
+
+func formatDegree(deg float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", deg), "0"), ".")
+}
+
+/*
+Return a name for each qbit line like "a[0]", by register names and local indexes.
+
+Qbits which are not in any register are named "q[i]" with the global index.
+*/
+func (q *QBitsCircuit) qbitLabels() []string {
+	labels := make([]string, q.QBitNumber)
+	for i := range labels {
+		labels[i] = fmt.Sprintf("q[%d]", i)
+	}
+	for r, reg := range q.qBitRegisters {
+		name := reg.Name
+		if name == "" {
+			name = registerDefaultName(r)
+		}
+		for local, qb := range reg.qbitList {
+			labels[qbitIndex(qb)] = fmt.Sprintf("%s[%d]", name, local)
+		}
+	}
+	return labels
+}
+
+/*
+Return the name of the i-th register which has no name, as in DumpAll.
+*/
+func registerDefaultName(i int) string {
+	return fmt.Sprintf("Reg%d", i+1)
+}
+
+/*
+Render all recorded operations as a text diagram, one line per qbit.
+
+Controls are drawn as ●, swaps as × and space operations as a ░ column.
+*/
+func (q *QBitsCircuit) Draw() string {
+	return q.DrawOperations(q.GetOperations())
+}
+
+/*
+Render operations as a text diagram using the qbit names of this circuit.
+*/
+func (q *QBitsCircuit) DrawOperations(ops []Operation) string {
+	n := int(q.QBitNumber)
+	labels := q.qbitLabels()
+	layers := layerOperations(q.QBitNumber, ops)
+
+	// cells[i][c] is the text on qbit line i, links[i][c] tells whether line i and i+1 are connected
+	cells := make([][]string, n)
+	links := make([][]bool, n)
+	widths := make([]int, len(layers))
+	for i := 0; i < n; i++ {
+		cells[i] = make([]string, len(layers))
+		links[i] = make([]bool, len(layers))
+	}
+
+	for c, layer := range layers {
+		widths[c] = 1
+		for _, k := range layer {
+			op := ops[k]
+			if op.OpName == OperationTypeSpace {
+				for i := 0; i < n; i++ {
+					cells[i][c] = "░"
+					links[i][c] = false
+				}
+				continue
+			}
+			lo, hi := operationSpan(op)
+			for i := lo; i <= hi; i++ {
+				cells[i][c] = "┼"
+				if i < hi {
+					links[i][c] = true
+				}
+			}
+			for _, ctrl := range op.ControlQBits {
+				cells[qbitIndex(ctrl)][c] = "●"
+			}
+			if op.OpName == OperationTypeSwap {
+				cells[qbitIndex(op.TargetQBit)][c] = "×"
+				cells[qbitIndex(op.SwapQBit)][c] = "×"
+			} else if op.OpName == OperationTypeNot && len(op.ControlQBits) > 0 {
+				cells[qbitIndex(op.TargetQBit)][c] = "⊕"
+			} else if op.OpName == OperationTypeGate && len(op.TargetQBits) > 1 {
+				for i, qb := range op.TargetQBits {
+					cells[qbitIndex(qb)][c] = fmt.Sprintf("%s[%d]", op.GateName, i)
+				}
+			} else {
+				cells[qbitIndex(op.TargetQBit)][c] = operationLabel(op)
+			}
+		}
+		for i := 0; i < n; i++ {
+			if w := utf8.RuneCountInString(cells[i][c]); w > widths[c] {
+				widths[c] = w
+			}
+		}
+	}
+
+	labelWidth := 0
+	for _, l := range labels {
+		if len(l) > labelWidth {
+			labelWidth = len(l)
+		}
+	}
+
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		sb.WriteString(fmt.Sprintf("%*s: ─", labelWidth, labels[i]))
+		for c := range layers {
+			sb.WriteString(centerCell(cells[i][c], widths[c], "─"))
+			sb.WriteString("─")
+		}
+		sb.WriteString("\n")
+
+		if i == n-1 {
+			break
+		}
+		gap := strings.Repeat(" ", labelWidth+3)
+		for c := range layers {
+			s := " "
+			if links[i][c] {
+				s = "│"
+			} else if cells[i][c] == "░" {
+				s = "░"
+			}
+			gap += centerCell(s, widths[c], " ") + " "
+		}
+		sb.WriteString(strings.TrimRight(gap, " ") + "\n")
+	}
+	return sb.String()
+}
+
+/*
+Center s in a cell of width runes, filling both sides with fill.
+*/
+func centerCell(s string, width int, fill string) string {
+	if s == "" {
+		return strings.Repeat(fill, width)
+	}
+	pad := width - utf8.RuneCountInString(s)
+	left := pad / 2
+	return strings.Repeat(fill, left) + s + strings.Repeat(fill, pad-left)
+}
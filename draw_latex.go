@@ -0,0 +1,202 @@
+package goqkit
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+/*
+Options of the quantikz LaTeX export.
+*/
+type QuantikzOptions struct {
+	//Write rotation and phase angles in degrees instead of radians
+	Degrees bool
+}
+
+/*
+Export all recorded operations as a quantikz environment.
+
+See OperationsQuantikz.
+*/
+func (q *QBitsCircuit) Quantikz(opts QuantikzOptions) string {
+	return q.OperationsQuantikz(q.GetOperations(), opts)
+}
+
+/*
+Export operations as a "\begin{quantikz} ... \end{quantikz}" block for the LaTeX quantikz package.
+
+Wires are labeled by register names, controls are \ctrl, controlled Not targets are \targ,
+swaps are \swap and \targX, reads are \meter with the read value and space operations are \slice.
+Conditional gates of CIf are boxes with the condition appended to the label, e.g. "X [c=1]".
+*/
+func (q *QBitsCircuit) OperationsQuantikz(ops []Operation, opts QuantikzOptions) string {
+	n := int(q.QBitNumber)
+	layers := layerOperations(q.QBitNumber, ops)
+
+	rows := make([][]string, n)
+	for i := 0; i < n; i++ {
+		rows[i] = []string{fmt.Sprintf(`\lstick{%s}`, q.quantikzWireLabel(i))}
+	}
+
+	for _, layer := range layers {
+		cells := make([]string, n)
+		for _, k := range layer {
+			op := ops[k]
+			if op.OpName == OperationTypeSpace {
+				cells[0] = `\slice{}`
+				continue
+			}
+			t := qbitIndex(op.TargetQBit)
+			cond := quantikzCondition(op)
+			for _, ctrl := range op.ControlQBits {
+				c := qbitIndex(ctrl)
+				cells[c] = fmt.Sprintf(`\ctrl{%d}`, t-c)
+			}
+			switch {
+			case op.OpName == OperationTypeSwap && op.Condition != nil:
+				s := qbitIndex(op.SwapQBit)
+				cells[t] = fmt.Sprintf(`\gate{%s_{0}%s}`, quantikzGateLabel(op, opts), cond)
+				cells[s] = fmt.Sprintf(`\gate{%s_{1}%s}`, quantikzGateLabel(op, opts), cond)
+			case op.OpName == OperationTypeSwap:
+				s := qbitIndex(op.SwapQBit)
+				cells[t] = fmt.Sprintf(`\swap{%d}`, s-t)
+				cells[s] = `\targX{}`
+			case op.OpName == OperationTypeNot && len(op.ControlQBits) > 0 && op.Condition == nil:
+				cells[t] = `\targ{}`
+			case op.OpName == OperationTypeRead:
+				if len(op.Options) > 0 {
+					cells[t] = fmt.Sprintf(`\meter{%d%s}`, int(op.Options[0]), cond)
+				} else {
+					cells[t] = fmt.Sprintf(`\meter{%s}`, cond)
+				}
+			case op.OpName == OperationTypeGate && len(op.TargetQBits) > 1:
+				for i, qb := range op.TargetQBits {
+					cells[qbitIndex(qb)] = fmt.Sprintf(`\gate{%s_{%d}%s}`, quantikzGateLabel(op, opts), i, cond)
+				}
+			default:
+				cells[t] = fmt.Sprintf(`\gate{%s%s}`, quantikzGateLabel(op, opts), cond)
+			}
+		}
+		for i := 0; i < n; i++ {
+			if cells[i] == "" {
+				cells[i] = `\qw`
+			}
+			rows[i] = append(rows[i], cells[i])
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\\begin{quantikz}\n")
+	for i, row := range rows {
+		sb.WriteString(strings.Join(append(row, `\qw`), " & "))
+		if i != n-1 {
+			sb.WriteString(` \\`)
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\\end{quantikz}\n")
+	return sb.String()
+}
+
+/*
+Return the math mode wire label of the qbit line i, e.g. "$\mathrm{a}_{0}$".
+*/
+func (q *QBitsCircuit) quantikzWireLabel(i int) string {
+	label := q.qbitLabels()[i]
+	open := strings.LastIndex(label, "[")
+	name, idx := label[:open], strings.TrimSuffix(label[open+1:], "]")
+	return fmt.Sprintf(`$\mathrm{%s}_{%s}$`, latexMathEscape(name), idx)
+}
+
+/*
+Return the condition of a conditional gate as a suffix of its math mode label, e.g. "\,[\mathrm{c}=1]",
+or "" for an unconditional gate.
+*/
+func quantikzCondition(op Operation) string {
+	if op.Condition == nil {
+		return ""
+	}
+	return fmt.Sprintf(`\,[\mathrm{%s}=%d]`, latexMathEscape(op.Condition.Register), op.Condition.Value)
+}
+
+func quantikzGateLabel(op Operation, opts QuantikzOptions) string {
+	switch op.OpName {
+	case OperationTypeNot, OperationTypeX:
+		return "X"
+	case OperationTypeRotate:
+		axis, deg := rotationAxis(op)
+		return fmt.Sprintf(`R_%s(%s)`, axis, latexAngle(deg, opts))
+	case OperationTypePhase:
+		return fmt.Sprintf(`P(%s)`, latexAngle(optionAt(op, 0), opts))
+	case OperationTypeU3:
+		return fmt.Sprintf(`U_3(%s, %s, %s)`, latexAngle(optionAt(op, 0), opts), latexAngle(optionAt(op, 1), opts), latexAngle(optionAt(op, 2), opts))
+	case OperationTypeSX:
+		return `\sqrt{X}`
+	case OperationTypeGate:
+		name := strings.TrimSuffix(op.GateName, GateInverseSuffix)
+		if name != op.GateName {
+			return fmt.Sprintf(`\text{%s}^\dagger`, latexEscape(name))
+		}
+		return fmt.Sprintf(`\text{%s}`, latexEscape(name))
+	case OperationTypeHad, OperationTypeY, OperationTypeZ, OperationTypeWrite:
+		return op.OpName
+	case OperationTypeReset:
+		return `\ket{0}`
+	case OperationTypeSwap:
+		return `\text{SWAP}`
+	}
+	return fmt.Sprintf(`\text{%s}`, latexEscape(op.OpName))
+}
+
+/*
+Format an angle given in degrees, as a multiple of pi when it is a simple fraction of pi.
+*/
+func latexAngle(deg float64, opts QuantikzOptions) string {
+	if opts.Degrees {
+		return formatDegree(deg) + `^\circ`
+	}
+	x := deg / 180
+	for den := 1; den <= 64; den *= 2 {
+		num := x * float64(den)
+		if math.Abs(num-math.Round(num)) > 1e-9 {
+			continue
+		}
+		p := int(math.Round(num))
+		sign := ""
+		if p < 0 {
+			sign = "-"
+			p = -p
+		}
+		switch {
+		case p == 0:
+			return "0"
+		case den == 1 && p == 1:
+			return sign + `\pi`
+		case den == 1:
+			return fmt.Sprintf(`%s%d\pi`, sign, p)
+		case p == 1:
+			return fmt.Sprintf(`%s\pi/%d`, sign, den)
+		}
+		return fmt.Sprintf(`%s%d\pi/%d`, sign, p, den)
+	}
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.4f", deg*math.Pi/180), "0"), ".")
+}
+
+/*
+Escape a label for text mode, e.g. inside \text{}.
+*/
+func latexEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\textbackslash{}`, `_`, `\_`, `%`, `\%`, `&`, `\&`, `#`, `\#`, `$`, `\$`, `{`, `\{`, `}`, `\}`,
+		`^`, `\textasciicircum{}`, `~`, `\textasciitilde{}`)
+	return r.Replace(s)
+}
+
+/*
+Escape a label for math mode, e.g. inside \mathrm{}, where the text mode commands are invalid.
+*/
+func latexMathEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\backslash{}`, `_`, `\_`, `%`, `\%`, `&`, `\&`, `#`, `\#`, `$`, `\$`, `{`, `\{`, `}`, `\}`,
+		`^`, `\wedge{}`, `~`, `\sim{}`, ` `, `\ `)
+	return r.Replace(s)
+}
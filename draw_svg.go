@@ -0,0 +1,229 @@
+package goqkit
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Style of SVG circuit diagrams. Sizes are in pixels and colors are any SVG color.
+*/
+type SVGStyle struct {
+	//Height of a gate box, also the minimum width of a gate box
+	GateSize float64
+	//Horizontal space between columns
+	ColumnSpacing float64
+	//Vertical distance between qbit lines
+	LineSpacing float64
+	//Margin around the diagram
+	Margin float64
+
+	FontFamily string
+	FontSize   float64
+
+	BackgroundColor string
+	LineColor       string
+	TextColor       string
+	GateFill        string
+	GateStroke      string
+	//Fill of gates which are not built in, e.g. user defined composite gates
+	CustomGateFill string
+	MeasureFill    string
+	BarrierColor   string
+}
+
+/*
+Return the default style of SVG circuit diagrams.
+*/
+func DefaultSVGStyle() SVGStyle {
+	return SVGStyle{
+		GateSize:        32,
+		ColumnSpacing:   16,
+		LineSpacing:     48,
+		Margin:          16,
+		FontFamily:      "Helvetica, Arial, sans-serif",
+		FontSize:        13,
+		BackgroundColor: "white",
+		LineColor:       "black",
+		TextColor:       "black",
+		GateFill:        "#dbe9ff",
+		GateStroke:      "black",
+		CustomGateFill:  "#ffe8c2",
+		MeasureFill:     "#e6e6e6",
+		BarrierColor:    "#999999",
+	}
+}
+
+/*
+Render all recorded operations as an SVG diagram.
+*/
+func (q *QBitsCircuit) DrawSVG(style SVGStyle) string {
+	return q.DrawOperationsSVG(q.GetOperations(), style)
+}
+
+/*
+Render operations as an SVG diagram using the qbit names of this circuit.
+
+Multi-controlled gates are joined by a vertical line, swaps are drawn as two crosses,
+reads as meters with the read value, and unknown operation types as boxes with their name.
+*/
+func (q *QBitsCircuit) DrawOperationsSVG(ops []Operation, style SVGStyle) string {
+	n := int(q.QBitNumber)
+	labels := q.qbitLabels()
+	layers := layerOperations(q.QBitNumber, ops)
+
+	charWidth := style.FontSize * 0.6
+	labelWidth := 0.0
+	for _, l := range labels {
+		labelWidth = math.Max(labelWidth, float64(utf8.RuneCountInString(l))*charWidth)
+	}
+
+	// width of every column from its widest gate box
+	widths := make([]float64, len(layers))
+	for c, layer := range layers {
+		widths[c] = style.GateSize
+		for _, k := range layer {
+			if svgHasBox(ops[k]) {
+				label := operationLabel(ops[k])
+				if len(ops[k].TargetQBits) > 1 {
+					label += "[0]"
+				}
+				w := float64(utf8.RuneCountInString(label))*charWidth + style.FontSize
+				widths[c] = math.Max(widths[c], w)
+			}
+		}
+	}
+
+	x0 := style.Margin + labelWidth + style.ColumnSpacing
+	width := x0 + style.Margin
+	for _, w := range widths {
+		width += w + style.ColumnSpacing
+	}
+	height := 2*style.Margin + float64(n-1)*style.LineSpacing + style.GateSize
+	lineY := func(i int) float64 {
+		return style.Margin + style.GateSize/2 + float64(i)*style.LineSpacing
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="%s" font-size="%.0f">`+"\n",
+		width, height, width, height, html.EscapeString(style.FontFamily), style.FontSize))
+	sb.WriteString(fmt.Sprintf(`<rect width="100%%" height="100%%" fill="%s"/>`+"\n", style.BackgroundColor))
+
+	for i := 0; i < n; i++ {
+		y := lineY(i)
+		sb.WriteString(fmt.Sprintf(`<text x="%.1f" y="%.1f" fill="%s" text-anchor="end" dominant-baseline="central">%s</text>`+"\n",
+			style.Margin+labelWidth, y, style.TextColor, html.EscapeString(labels[i])))
+		sb.WriteString(fmt.Sprintf(`<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`+"\n",
+			x0, y, width-style.Margin, y, style.LineColor))
+	}
+
+	x := x0
+	for c, layer := range layers {
+		cx := x + widths[c]/2
+		for _, k := range layer {
+			q.svgOperation(&sb, ops[k], cx, widths[c], lineY, height, style)
+		}
+		x += widths[c] + style.ColumnSpacing
+	}
+
+	sb.WriteString("</svg>\n")
+	return sb.String()
+}
+
+/*
+Return true if the operation is drawn as a box with its label on the target line.
+*/
+func svgHasBox(op Operation) bool {
+	switch op.OpName {
+	case OperationTypeSpace, OperationTypeSwap:
+		return false
+	case OperationTypeNot:
+		return len(op.ControlQBits) == 0
+	}
+	return true
+}
+
+func (q *QBitsCircuit) svgOperation(sb *strings.Builder, op Operation, cx, w float64, lineY func(int) float64, height float64, style SVGStyle) {
+	if op.OpName == OperationTypeSpace {
+		sb.WriteString(fmt.Sprintf(`<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s" stroke-width="2" stroke-dasharray="4,4"/>`+"\n",
+			cx, style.Margin, cx, height-style.Margin, style.BarrierColor))
+		return
+	}
+
+	lo, hi := operationSpan(op)
+	if hi > lo {
+		sb.WriteString(fmt.Sprintf(`<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`+"\n",
+			cx, lineY(lo), cx, lineY(hi), style.LineColor))
+	}
+	for _, ctrl := range op.ControlQBits {
+		sb.WriteString(fmt.Sprintf(`<circle cx="%.1f" cy="%.1f" r="%.1f" fill="%s"/>`+"\n",
+			cx, lineY(qbitIndex(ctrl)), style.GateSize/8, style.LineColor))
+	}
+
+	ty := lineY(qbitIndex(op.TargetQBit))
+	switch {
+	case op.OpName == OperationTypeSwap:
+		for _, qb := range []uint{op.TargetQBit, op.SwapQBit} {
+			y := lineY(qbitIndex(qb))
+			d := style.GateSize / 5
+			sb.WriteString(fmt.Sprintf(`<path d="M %.1f %.1f L %.1f %.1f M %.1f %.1f L %.1f %.1f" stroke="%s" stroke-width="2"/>`+"\n",
+				cx-d, y-d, cx+d, y+d, cx-d, y+d, cx+d, y-d, style.LineColor))
+		}
+	case op.OpName == OperationTypeNot && len(op.ControlQBits) > 0:
+		r := style.GateSize / 3
+		sb.WriteString(fmt.Sprintf(`<circle cx="%.1f" cy="%.1f" r="%.1f" fill="%s" stroke="%s"/>`+"\n",
+			cx, ty, r, style.BackgroundColor, style.LineColor))
+		sb.WriteString(fmt.Sprintf(`<path d="M %.1f %.1f L %.1f %.1f M %.1f %.1f L %.1f %.1f" stroke="%s"/>`+"\n",
+			cx-r, ty, cx+r, ty, cx, ty-r, cx, ty+r, style.LineColor))
+	case op.OpName == OperationTypeRead:
+		s := style.GateSize
+		sb.WriteString(fmt.Sprintf(`<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s" stroke="%s"/>`+"\n",
+			cx-s/2, ty-s/2, s, s, style.MeasureFill, style.GateStroke))
+		// meter: an arc and a needle
+		sb.WriteString(fmt.Sprintf(`<path d="M %.1f %.1f A %.1f %.1f 0 0 1 %.1f %.1f" fill="none" stroke="%s"/>`+"\n",
+			cx-s/3, ty+s/6, s/3, s/3, cx+s/3, ty+s/6, style.LineColor))
+		sb.WriteString(fmt.Sprintf(`<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`+"\n",
+			cx, ty+s/6, cx+s/4, ty-s/4, style.LineColor))
+		if len(op.Options) > 0 {
+			sb.WriteString(fmt.Sprintf(`<text x="%.1f" y="%.1f" fill="%s" text-anchor="middle" font-size="%.0f">%d</text>`+"\n",
+				cx, ty+s/2+style.FontSize, style.TextColor, style.FontSize*0.8, int(op.Options[0])))
+		}
+	default:
+		fill := style.GateFill
+		if !isBuiltinOperation(op.OpName) {
+			fill = style.CustomGateFill
+		}
+		s := style.GateSize
+		targets := []uint{op.TargetQBit}
+		if op.OpName == OperationTypeGate && len(op.TargetQBits) > 1 {
+			targets = op.TargetQBits
+		}
+		for i, qb := range targets {
+			y := lineY(qbitIndex(qb))
+			label := operationLabel(op)
+			if len(targets) > 1 {
+				label = fmt.Sprintf("%s[%d]", label, i)
+			}
+			sb.WriteString(fmt.Sprintf(`<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s" stroke="%s"/>`+"\n",
+				cx-w/2, y-s/2, w, s, fill, style.GateStroke))
+			sb.WriteString(fmt.Sprintf(`<text x="%.1f" y="%.1f" fill="%s" text-anchor="middle" dominant-baseline="central">%s</text>`+"\n",
+				cx, y, style.TextColor, html.EscapeString(label)))
+		}
+	}
+}
+
+/*
+Return true if the operation type is one of the OperationType constants.
+*/
+func isBuiltinOperation(opName string) bool {
+	switch opName {
+	case OperationTypeSpace, OperationTypeRead, OperationTypeWrite, OperationTypeReset, OperationTypeHad, OperationTypePhase,
+		OperationTypeRotate, OperationTypeNot, OperationTypeSwap, OperationTypeX, OperationTypeY, OperationTypeZ,
+		OperationTypeU3, OperationTypeSX:
+		return true
+	}
+	return false
+}
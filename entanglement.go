@@ -0,0 +1,309 @@
+package goqkit
+
+import (
+	"fmt"
+	"github.com/takezo5096/goqkit/mat"
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+/*
+Return the compact index of the bits of index selected by mask.
+
+The lowest selected bit of mask becomes bit 0 of the result, the next one bit 1, and so on.
+*/
+func compactBits(index uint, mask uint) uint {
+	var r uint
+	var pos uint
+	for b := uint(1); b != 0 && b <= mask; b <<= 1 {
+		if mask&b == 0 {
+			continue
+		}
+		if index&b != 0 {
+			r |= 1 << pos
+		}
+		pos++
+	}
+	return r
+}
+
+func bitCount(mask uint) uint {
+	var n uint
+	for ; mask != 0; mask &= mask - 1 {
+		n++
+	}
+	return n
+}
+
+/*
+Return the reduced density matrix of the qbits specified as val by tracing out all other qbits.
+
+val: global qbits value to keep
+
+The matrix index is made of the kept qbits, the lowest qbit of val is bit 0 of the index.
+*/
+func (q *QBitsCircuit) ReducedDensityMatrix(val int) mat.Matrix {
+	keep := uint(val) & (q.RawQBits.N - 1)
+	env := (q.RawQBits.N - 1) &^ keep
+	dim := uint(1) << bitCount(keep)
+
+	// amplitudes grouped by the state of the environment
+	groups := make(map[uint][]complex128)
+	for i, a := range q.RawQBits.Data {
+		if a == 0 {
+			continue
+		}
+		e := compactBits(uint(i), env)
+		g, ok := groups[e]
+		if !ok {
+			g = make([]complex128, dim)
+			groups[e] = g
+		}
+		g[compactBits(uint(i), keep)] = a
+	}
+
+	rho := mat.NewMatrix(dim, dim)
+	for _, g := range groups {
+		for r, ar := range g {
+			if ar == 0 {
+				continue
+			}
+			for c, ac := range g {
+				rho.Data[r][c] += ar * cmplx.Conj(ac)
+			}
+		}
+	}
+	return rho
+}
+
+/*
+Return the von Neumann entropy (in bits) of the qbits specified as val.
+
+val: global qbits value
+*/
+func (q *QBitsCircuit) VonNeumannEntropy(val int) float64 {
+	rho := q.ReducedDensityMatrix(val)
+	return vonNeumannEntropy(&rho)
+}
+
+func vonNeumannEntropy(rho *mat.Matrix) float64 {
+	values, _ := rho.EigenHermitian()
+	s := 0.0
+	for _, l := range values {
+		if l > 1e-15 {
+			s -= l * math.Log2(l)
+		}
+	}
+	return s
+}
+
+/*
+Return the Rényi entropy of order alpha (in bits) of the qbits specified as val.
+
+alpha = 1 is the von Neumann entropy and alpha = 0 is the logarithm of the rank.
+
+val: global qbits value
+*/
+func (q *QBitsCircuit) RenyiEntropy(val int, alpha float64) float64 {
+	rho := q.ReducedDensityMatrix(val)
+	if alpha == 1 {
+		return vonNeumannEntropy(&rho)
+	}
+	values, _ := rho.EigenHermitian()
+	s := 0.0
+	for _, l := range values {
+		if l > 1e-15 {
+			s += math.Pow(l, alpha)
+		}
+	}
+	return math.Log2(s) / (1 - alpha)
+}
+
+/*
+Return the purity Tr(rho^2) of the qbits specified as val.
+
+val: global qbits value
+*/
+func (q *QBitsCircuit) Purity(val int) float64 {
+	rho := q.ReducedDensityMatrix(val)
+	p := 0.0
+	for _, row := range rho.Data {
+		for _, e := range row {
+			p += real(e)*real(e) + imag(e)*imag(e)
+		}
+	}
+	return p
+}
+
+/*
+Return the Wootters concurrence of a two qbits subsystem.
+
+val: global qbits value which must have exactly two bits
+*/
+func (q *QBitsCircuit) Concurrence(val int) (float64, error) {
+	if bitCount(uint(val)&(q.RawQBits.N-1)) != 2 {
+		return 0, fmt.Errorf("concurrence needs exactly 2 qbits, got 0x%x", val)
+	}
+	rho := q.ReducedDensityMatrix(val)
+
+	// rho tilde = (Y x Y) rho* (Y x Y), Y x Y flips both bits with sign (-1)^(number of ones)
+	yy := mat.NewMatrix(4, 4)
+	yy.Set(0, 3, -1)
+	yy.Set(1, 2, 1)
+	yy.Set(2, 1, 1)
+	yy.Set(3, 0, -1)
+	rhoConj := mat.NewMatrix(4, 4)
+	var i, j uint
+	for i = 0; i < 4; i++ {
+		for j = 0; j < 4; j++ {
+			rhoConj.Set(i, j, cmplx.Conj(rho.At(i, j)))
+		}
+	}
+	tmp := yy.Mul(&rhoConj)
+	rhoTilde := tmp.Mul(&yy)
+
+	// eigenvalues of sqrt(rho) rhoTilde sqrt(rho) are the squares of the lambdas
+	sqrtRho := hermitianSqrt(&rho)
+	tmp = sqrtRho.Mul(&rhoTilde)
+	r := tmp.Mul(&sqrtRho)
+	values, _ := r.EigenHermitian()
+
+	lambdas := make([]float64, len(values))
+	for k, v := range values {
+		lambdas[k] = math.Sqrt(math.Max(v, 0))
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(lambdas)))
+
+	return math.Max(0, lambdas[0]-lambdas[1]-lambdas[2]-lambdas[3]), nil
+}
+
+func hermitianSqrt(m *mat.Matrix) mat.Matrix {
+	values, vectors := m.EigenHermitian()
+	d := mat.NewMatrix(m.Rows, m.Cols)
+	for k, v := range values {
+		d.Set(uint(k), uint(k), complex(math.Sqrt(math.Max(v, 0)), 0))
+	}
+	tmp := vectors.Mul(&d)
+	vt := vectors.ConjTranspose()
+	return tmp.Mul(&vt)
+}
+
+/*
+Return the negativity between the qbits specified as valA and valB.
+
+The other qbits are traced out and the partial transpose is taken over valB.
+
+valA, valB: global qbits values which must not overlap
+*/
+func (q *QBitsCircuit) Negativity(valA int, valB int) (float64, error) {
+	if valA&valB != 0 {
+		return 0, fmt.Errorf("negativity needs disjoint subsystems, 0x%x and 0x%x overlap", valA, valB)
+	}
+	keep := uint(valA | valB)
+	rho := q.ReducedDensityMatrix(int(keep))
+	bMask := compactBits(uint(valB), keep)
+
+	pt := mat.NewMatrix(rho.Rows, rho.Cols)
+	var r, c uint
+	for r = 0; r < rho.Rows; r++ {
+		for c = 0; c < rho.Cols; c++ {
+			// exchange the B part of the row and column index
+			r2 := r&^bMask | c&bMask
+			c2 := c&^bMask | r&bMask
+			pt.Set(r2, c2, rho.At(r, c))
+		}
+	}
+
+	values, _ := pt.EigenHermitian()
+	n := 0.0
+	for _, v := range values {
+		if v < 0 {
+			n -= v
+		}
+	}
+	return n, nil
+}
+
+/*
+Schmidt decomposition of the state between two registers: |psi> = sum_k c_k |a_k>|b_k>.
+
+The two registers must hold all qbits of the circuit between them.
+Coefficients are in descending order and the vectors are indexed by the local value of each register.
+*/
+func (q *QBitsCircuit) SchmidtDecomposition(a, b *Register) ([]float64, []mat.Vector, []mat.Vector, error) {
+	all := q.RawQBits.N - 1
+	if a.qBits&b.qBits != 0 || a.qBits|b.qBits != all {
+		return nil, nil, nil, fmt.Errorf("schmidt decomposition needs two disjoint registers which hold all qbits")
+	}
+
+	// the eigenvectors are indexed by the local value of a, like the amplitudes below
+	rho := a.ReducedDensityMatrix()
+	values, vectors := rho.EigenHermitian()
+
+	dimA := uint(1) << uint(a.NumberOfQBits())
+	dimB := uint(1) << uint(b.NumberOfQBits())
+
+	coeffs := make([]float64, 0)
+	aVecs := make([]mat.Vector, 0)
+	bVecs := make([]mat.Vector, 0)
+	for k := len(values) - 1; k >= 0; k-- {
+		if values[k] < 1e-12 {
+			break
+		}
+		c := math.Sqrt(values[k])
+		av := mat.NewVector(dimA)
+		var i uint
+		for i = 0; i < dimA; i++ {
+			av.Set(i, vectors.At(i, uint(k)))
+		}
+		// b_k = (1/c_k) sum_a conj(a_k[a]) psi(a, b)
+		bv := mat.NewVector(dimB)
+		for idx, amp := range q.RawQBits.Data {
+			if amp == 0 {
+				continue
+			}
+			va := uint(a.valueOf(uint(idx)))
+			vb := uint(b.valueOf(uint(idx)))
+			bv.Data[vb] += cmplx.Conj(av.At(va)) * amp / complex(c, 0)
+		}
+		coeffs = append(coeffs, c)
+		aVecs = append(aVecs, av)
+		bVecs = append(bVecs, bv)
+	}
+	return coeffs, aVecs, bVecs, nil
+}
+
+/*
+Return the reduced density matrix of this register, the matrix index is the local value of the register.
+*/
+func (reg *Register) ReducedDensityMatrix() mat.Matrix {
+	rho := reg.circuit.ReducedDensityMatrix(int(reg.qBits))
+	// the local order differs from the order of the global qbits for aliases
+	dim := uint(1) << uint(reg.numberOfQBits)
+	index := make([]uint, dim)
+	for l := uint(0); l < dim; l++ {
+		index[l] = compactBits(uint(reg.ToGlobalQBits(int(l))), reg.qBits)
+	}
+	local := mat.NewMatrix(dim, dim)
+	for r := uint(0); r < dim; r++ {
+		for c := uint(0); c < dim; c++ {
+			local.Data[r][c] = rho.Data[index[r]][index[c]]
+		}
+	}
+	return local
+}
+
+/*
+Return the von Neumann entropy (in bits) of this register.
+*/
+func (reg *Register) VonNeumannEntropy() float64 {
+	return reg.circuit.VonNeumannEntropy(int(reg.qBits))
+}
+
+/*
+Return the purity Tr(rho^2) of this register.
+*/
+func (reg *Register) Purity() float64 {
+	return reg.circuit.Purity(int(reg.qBits))
+}
@@ -0,0 +1,757 @@
+package goqkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/takezo5096/goqkit/mat"
+	"math"
+	"math/cmplx"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+Suffix of the name of the inverse of a user defined gate, e.g. "Oracle†".
+*/
+const GateInverseSuffix = "†"
+
+/*
+A named gate on NumberOfQBits qbits, defined by a unitary matrix or by operations.
+
+The qbits of the gate are numbered from 0. In Matrix, basis index bit i is qbit i of the gate.
+Operations act on the global qbits 1<<i of the gate and may use other user defined gates.
+*/
+type GateDefinition struct {
+	Name          string
+	NumberOfQBits int
+	//2^NumberOfQBits square unitary matrix, nil for a gate defined by operations
+	Matrix *mat.Matrix
+	//Operations of the gate, nil for a gate defined by a matrix
+	Operations []Operation
+
+	registry *GateRegistry
+	//primitive operations of Expand, synthesized once for matrix gates
+	expanded []Operation
+}
+
+/*
+Named user defined gates.
+*/
+type GateRegistry struct {
+	mu    sync.RWMutex
+	gates map[string]*GateDefinition
+}
+
+/*
+Make an empty gate registry.
+*/
+func NewGateRegistry() *GateRegistry {
+	return &GateRegistry{gates: make(map[string]*GateDefinition)}
+}
+
+/*
+Registry of the gates which QBitsCircuit.Gate applies, and which are used to simulate,
+expand and dump user defined gate operations, unless a circuit has its own registry (SetGateRegistry).
+*/
+var DefaultGateRegistry = NewGateRegistry()
+
+/*
+Use the registry for the user defined gates of this circuit: Gate applies them from it,
+and the unitary, expansion, transpilation, metrics and dumps of the recorded operations look them up in it.
+
+registry: DefaultGateRegistry when nil
+*/
+func (q *QBitsCircuit) SetGateRegistry(registry *GateRegistry) {
+	q.gates = registry
+}
+
+/*
+Return the registry of the user defined gates of this circuit.
+*/
+func (q *QBitsCircuit) GateRegistry() *GateRegistry {
+	if q.gates == nil {
+		return DefaultGateRegistry
+	}
+	return q.gates
+}
+
+/*
+Define a gate by its unitary matrix.
+
+m: 2^k x 2^k unitary matrix of a gate on k qbits
+*/
+func (r *GateRegistry) DefineMatrix(name string, m mat.Matrix) error {
+	k := 0
+	for 1<<uint(k) < m.Rows {
+		k++
+	}
+	if m.Rows != m.Cols || 1<<uint(k) != m.Rows || k == 0 {
+		return fmt.Errorf("gate %q: matrix is %dx%d, not 2^k x 2^k", name, m.Rows, m.Cols)
+	}
+	if !isUnitaryMatrix(m) {
+		return fmt.Errorf("gate %q: matrix is not unitary", name)
+	}
+	c := m.Copy()
+	return r.define(&GateDefinition{Name: name, NumberOfQBits: k, Matrix: &c})
+}
+
+/*
+Define a gate by a sub circuit.
+
+qbits: global value of the qbits of the sub circuit which become the qbits of the gate,
+the lowest one is qbit 0 of the gate. 0 takes all qbits which the sub circuit acts on.
+*/
+func (r *GateRegistry) DefineSubCircuit(name string, s *SubCircuit, qbits int) error {
+	if qbits == 0 {
+		qbits = s.QBits()
+	}
+	formal := qbitList(qbits)
+	ops, err := mapQBits(s.Operations(), formal, nil, 0)
+	if err != nil {
+		return fmt.Errorf("gate %q: %v", name, err)
+	}
+	return r.DefineOperations(name, len(formal), ops)
+}
+
+/*
+Define a gate on k qbits by operations on the global qbits 1<<0 ... 1<<(k-1).
+*/
+func (r *GateRegistry) DefineOperations(name string, k int, ops []Operation) error {
+	if k <= 0 {
+		return fmt.Errorf("gate %q: needs at least one qbit", name)
+	}
+	for i, op := range ops {
+		if !op.IsUnitary() {
+			return fmt.Errorf("gate %q: operation %d: operation %q is not unitary", name, i, op.OpName)
+		}
+		for _, qb := range op.QBits() {
+			if qbitIndex(qb) >= k {
+				return fmt.Errorf("gate %q: operation %d acts on qbit %d of a %d qbit gate", name, i, qbitIndex(qb), k)
+			}
+		}
+	}
+	return r.define(&GateDefinition{Name: name, NumberOfQBits: k, Operations: append([]Operation{}, ops...)})
+}
+
+func (r *GateRegistry) define(def *GateDefinition) error {
+	if def.Name == "" || strings.HasSuffix(def.Name, GateInverseSuffix) {
+		return fmt.Errorf("invalid gate name %q", def.Name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.gates[def.Name]; ok {
+		return fmt.Errorf("gate %q is already defined", def.Name)
+	}
+	if path := r.gatePath(def.Operations, def.Name, make(map[string]bool)); path != nil {
+		return fmt.Errorf("gate %q uses itself: %s", def.Name, strings.Join(append([]string{def.Name}, path...), " -> "))
+	}
+	def.registry = r
+	r.gates[def.Name] = def
+	return nil
+}
+
+/*
+Return the names of the gates from the operations to the gate name through the defined gates, or nil.
+
+Expansion would never end for a gate on such a path, so a definition which closes a cycle is rejected.
+The caller holds the lock.
+*/
+func (r *GateRegistry) gatePath(ops []Operation, name string, seen map[string]bool) []string {
+	for _, op := range ops {
+		if op.OpName != OperationTypeGate {
+			continue
+		}
+		base := strings.TrimSuffix(op.GateName, GateInverseSuffix)
+		if base == name {
+			return []string{base}
+		}
+		if seen[base] {
+			continue
+		}
+		seen[base] = true
+		if def, ok := r.gates[base]; ok {
+			if path := r.gatePath(def.Operations, name, seen); path != nil {
+				return append([]string{base}, path...)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+Remove a gate definition.
+*/
+func (r *GateRegistry) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.gates, name)
+}
+
+/*
+Return the definition of a gate. A name with GateInverseSuffix returns the inverse of the gate.
+*/
+func (r *GateRegistry) Lookup(name string) (*GateDefinition, bool) {
+	base := strings.TrimSuffix(name, GateInverseSuffix)
+	r.mu.RLock()
+	def, ok := r.gates[base]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	if base != name {
+		return def.Inverse(), true
+	}
+	return def, true
+}
+
+/*
+Return the names of all defined gates in ascending order.
+*/
+func (r *GateRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.gates))
+	for name := range r.gates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+Return the inverse of the gate, named with GateInverseSuffix.
+*/
+func (d *GateDefinition) Inverse() *GateDefinition {
+	inv := &GateDefinition{Name: inverseGateName(d.Name), NumberOfQBits: d.NumberOfQBits, registry: d.registry}
+	if d.Matrix != nil {
+		m := d.Matrix.ConjTranspose()
+		inv.Matrix = &m
+		return inv
+	}
+	inv.Operations = (&SubCircuit{operations: d.Operations}).Inverse().Operations()
+	return inv
+}
+
+func inverseGateName(name string) string {
+	if strings.HasSuffix(name, GateInverseSuffix) {
+		return strings.TrimSuffix(name, GateInverseSuffix)
+	}
+	return name + GateInverseSuffix
+}
+
+/*
+Return the gate as built in operations on the global qbits 1<<0 ... 1<<(NumberOfQBits-1).
+
+User defined gates in the operations are expanded recursively,
+and matrices are decomposed into two level unitaries, which is exact but long for many qbits.
+*/
+func (d *GateDefinition) Expand() ([]Operation, error) {
+	if d.Matrix != nil {
+		if d.expanded == nil {
+			d.expanded = synthesizeUnitary(d.NumberOfQBits, *d.Matrix)
+		}
+		return d.expanded, nil
+	}
+	return ExpandOperations(d.Operations, d.registry)
+}
+
+/*
+Return the 2^k x 2^k unitary matrix of the gate.
+*/
+func (d *GateDefinition) Unitary() (mat.Matrix, error) {
+	if d.Matrix != nil {
+		return d.Matrix.Copy(), nil
+	}
+	return operationsUnitary(uint(d.NumberOfQBits), d.Operations, d.registry)
+}
+
+/*
+Apply the gate to the state vector v.
+
+targets: global qbits of the gate, qbit i of the gate is targets[i]
+
+controlValue: global control qbits value
+*/
+func (d *GateDefinition) apply(v *mat.Vector, targets []uint, controlValue int) error {
+	if d.Matrix != nil {
+		applyMatrixK(v, targets, controlValue, d.Matrix)
+		return nil
+	}
+	ops, err := mapQBits(d.Operations, nil, targets, controlValue)
+	if err != nil {
+		return err
+	}
+	return applyOperations(v, ops, d.registry)
+}
+
+/*
+Apply the 2^k x 2^k matrix m to the k target qbits of v where the control qbits are all 1.
+*/
+func applyMatrixK(v *mat.Vector, targets []uint, controlValue int, m *mat.Matrix) {
+	dim := 1 << uint(len(targets))
+	var mask uint
+	for _, t := range targets {
+		mask |= t
+	}
+	idx := make([]uint, dim)
+	amps := make([]complex128, dim)
+	var i uint
+	for i = 0; i < v.N; i++ {
+		if i&mask != 0 || int(i)&controlValue != controlValue {
+			continue
+		}
+		for j := 0; j < dim; j++ {
+			x := i
+			for b, t := range targets {
+				if j&(1<<uint(b)) != 0 {
+					x |= t
+				}
+			}
+			idx[j] = x
+			amps[j] = v.Data[x]
+		}
+		for row := 0; row < dim; row++ {
+			var sum complex128
+			for col := 0; col < dim; col++ {
+				sum += m.Data[row][col] * amps[col]
+			}
+			v.Data[idx[row]] = sum
+		}
+	}
+}
+
+/*
+Map the qbits of operations and add controls.
+
+Without from, the global qbit 1<<i is mapped to to[i]; with from, from[i] is mapped to 1<<i.
+*/
+func mapQBits(ops []Operation, from, to []uint, controlValue int) ([]Operation, error) {
+	mapping := make(map[uint]uint)
+	if from != nil {
+		for i, qb := range from {
+			mapping[qb] = 1 << uint(i)
+		}
+	} else {
+		for i, qb := range to {
+			mapping[1<<uint(i)] = qb
+		}
+	}
+	m := func(qb uint) (uint, error) {
+		mapped, ok := mapping[qb]
+		if !ok {
+			return 0, fmt.Errorf("qbit %d is not a qbit of the gate", qbitIndex(qb))
+		}
+		return mapped, nil
+	}
+
+	result := make([]Operation, 0, len(ops))
+	for _, op := range ops {
+		if op.OpName == OperationTypeSpace {
+			result = append(result, op)
+			continue
+		}
+		mappedOp := op
+		var err error
+		if mappedOp.TargetQBit, err = m(op.TargetQBit); err != nil {
+			return nil, err
+		}
+		if op.OpName == OperationTypeSwap && op.SwapQBit != 0 {
+			if mappedOp.SwapQBit, err = m(op.SwapQBit); err != nil {
+				return nil, err
+			}
+		}
+		if op.TargetQBits != nil {
+			mappedOp.TargetQBits = make([]uint, len(op.TargetQBits))
+			for i, qb := range op.TargetQBits {
+				if mappedOp.TargetQBits[i], err = m(qb); err != nil {
+					return nil, err
+				}
+			}
+		}
+		controls := make([]uint, len(op.ControlQBits))
+		for i, qb := range op.ControlQBits {
+			if controls[i], err = m(qb); err != nil {
+				return nil, err
+			}
+		}
+		mappedOp.ControlQBits = controls
+		if len(controls) == 0 {
+			mappedOp.ControlQBits = nil
+		}
+		if mappedOp, err = controlledOperation(mappedOp, controlValue); err != nil {
+			return nil, err
+		}
+		result = append(result, mappedOp)
+	}
+	return result, nil
+}
+
+/*
+Apply a user defined gate of the gate registry of this circuit, which is recorded as one operation.
+
+name: name of the gate, with GateInverseSuffix for its inverse
+
+targets: global value of the qbits of the gate, the lowest one is qbit 0 of the gate
+
+controlValue: global control qbits value
+*/
+func (q *QBitsCircuit) Gate(name string, targets int, controlValue int) error {
+	return q.gate(name, qbitList(targets), controlValue)
+}
+
+/*
+Apply a user defined gate on the qbits in order, qbits[i] is qbit i of the gate.
+*/
+func (q *QBitsCircuit) gate(name string, qbits []uint, controlValue int) error {
+	if q.err != nil {
+		return fmt.Errorf("gate %q is skipped after an error: %v", name, q.err)
+	}
+	def, ok := q.GateRegistry().Lookup(name)
+	if !ok {
+		return fmt.Errorf("gate %q is not defined", name)
+	}
+	targets := 0
+	for _, qb := range qbits {
+		targets |= int(qb)
+	}
+	if len(qbits) != def.NumberOfQBits {
+		return fmt.Errorf("gate %q acts on %d qbits, got %d", name, def.NumberOfQBits, len(qbits))
+	}
+	if targets&controlValue != 0 {
+		return fmt.Errorf("gate %q: control qbits overlap target qbits", name)
+	}
+	if err := q.ValidateQBits(targets, controlValue); err != nil {
+		return fmt.Errorf("gate %q: %v", name, err)
+	}
+
+	if q.capturing == 0 && q.conditionHolds() {
+		if err := def.apply(&q.RawQBits, qbits, controlValue); err != nil {
+			return err
+		}
+	}
+
+	reg := q.GetRegister(targets)
+	op := Operation{OpName: OperationTypeGate, GateName: name, TargetQBit: qbits[0], TargetQBits: qbits, Condition: q.condition}
+	if reg != nil {
+		op.RegisterName = 1 << reg.shift
+		op.RegisterNameString = reg.Name
+	}
+	if controlValue != 0 {
+		op.ControlQBits = q.GetQBits(controlValue)
+	}
+	q.operations = append(q.operations, op)
+	return nil
+}
+
+/*
+Replace the user defined gate operations by their built in operations.
+
+registry: registry of the gates, DefaultGateRegistry when nil
+*/
+func ExpandOperations(ops []Operation, registry *GateRegistry) ([]Operation, error) {
+	if registry == nil {
+		registry = DefaultGateRegistry
+	}
+	result := make([]Operation, 0, len(ops))
+	for k, op := range ops {
+		if op.OpName != OperationTypeGate {
+			result = append(result, op)
+			continue
+		}
+		def, ok := registry.Lookup(op.GateName)
+		if !ok {
+			return nil, fmt.Errorf("operation %d: gate %q is not defined", k, op.GateName)
+		}
+		expanded, err := def.Expand()
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %v", k, err)
+		}
+		mapped, err := mapQBits(expanded, nil, op.TargetQBits, op.ControlValue())
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %v", k, err)
+		}
+		for _, m := range mapped {
+			m.RegisterName = op.RegisterName
+			m.RegisterNameString = op.RegisterNameString
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
+
+/*
+Return the recorded operations with user defined gates replaced by their built in operations.
+*/
+func (q *QBitsCircuit) ExpandedOperations() ([]Operation, error) {
+	return ExpandOperations(q.GetOperations(), q.GateRegistry())
+}
+
+/*
+Gate definition in a JSON dump.
+*/
+type DumpFormatGate struct {
+	Name          string `json:"name"`
+	NumberOfQBits int    `json:"number_of_qbits"`
+	//Rows of [real, imaginary] pairs
+	Matrix     [][][2]float64 `json:"matrix,omitempty"`
+	Operations []Operation    `json:"operations,omitempty"`
+}
+
+/*
+Return the dump format of the gates used by operations, including the gates used by their definitions.
+*/
+func (r *GateRegistry) dumpGates(ops []Operation) []DumpFormatGate {
+	var gates []DumpFormatGate
+	seen := make(map[string]bool)
+	var collect func(ops []Operation)
+	collect = func(ops []Operation) {
+		for _, op := range ops {
+			if op.OpName != OperationTypeGate {
+				continue
+			}
+			name := strings.TrimSuffix(op.GateName, GateInverseSuffix)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			def, ok := r.Lookup(name)
+			if !ok {
+				continue
+			}
+			gates = append(gates, def.dumpFormat())
+			collect(def.Operations)
+		}
+	}
+	collect(ops)
+	return gates
+}
+
+func (d *GateDefinition) dumpFormat() DumpFormatGate {
+	g := DumpFormatGate{Name: d.Name, NumberOfQBits: d.NumberOfQBits, Operations: d.Operations}
+	if d.Matrix != nil {
+		g.Matrix = make([][][2]float64, d.Matrix.Rows)
+		for i, row := range d.Matrix.Data {
+			g.Matrix[i] = make([][2]float64, len(row))
+			for j, x := range row {
+				g.Matrix[i][j] = [2]float64{real(x), imag(x)}
+			}
+		}
+	}
+	return g
+}
+
+/*
+Define the gates of a dump. Gates which are already defined the same way are skipped.
+*/
+func (r *GateRegistry) DefineDumpGates(gates []DumpFormatGate) error {
+	for _, g := range gates {
+		if def, ok := r.Lookup(g.Name); ok {
+			a, _ := json.Marshal(def.dumpFormat())
+			b, _ := json.Marshal(g)
+			if string(a) != string(b) {
+				return fmt.Errorf("gate %q is already defined differently", g.Name)
+			}
+			continue
+		}
+		var err error
+		if g.Matrix != nil {
+			m := mat.NewMatrix(uint(len(g.Matrix)), uint(len(g.Matrix)))
+			for i, row := range g.Matrix {
+				if len(row) != len(g.Matrix) {
+					return fmt.Errorf("gate %q: matrix is not square", g.Name)
+				}
+				for j, x := range row {
+					m.Set(uint(i), uint(j), complex(x[0], x[1]))
+				}
+			}
+			err = r.DefineMatrix(g.Name, m)
+		} else {
+			err = r.DefineOperations(g.Name, g.NumberOfQBits, g.Operations)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Parse a dump made by DumpAll.
+
+registry: registry where the gates of the dump are defined, nothing is defined when nil
+*/
+func ParseDump(data []byte, registry *GateRegistry) (DumpFormat, error) {
+	var df DumpFormat
+	if err := json.Unmarshal(data, &df); err != nil {
+		return df, err
+	}
+	if registry != nil {
+		if err := registry.DefineDumpGates(df.Gates); err != nil {
+			return df, err
+		}
+	}
+	return df, nil
+}
+
+func isUnitaryMatrix(m mat.Matrix) bool {
+	dag := m.ConjTranspose()
+	p := dag.Mul(&m)
+	for i := range p.Data {
+		for j, x := range p.Data[i] {
+			want := complex(0, 0)
+			if i == j {
+				want = 1
+			}
+			if cmplx.Abs(x-want) > 1e-9 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/*
+Decompose a 2^k x 2^k unitary matrix exactly, including its global phase, into built in operations
+on the global qbits 1<<0 ... 1<<(k-1).
+
+The matrix is reduced to the identity by two level unitaries (Givens rotations) column by column,
+and each two level unitary is a multi-controlled single qbit gate between controlled Nots along a Gray code.
+*/
+func synthesizeUnitary(k int, u mat.Matrix) []Operation {
+	if k == 1 {
+		return exactSingleQBit(1, nil, u)
+	}
+	d := 1 << uint(k)
+	w := u.Copy()
+	type twoLevel struct {
+		s, t int
+		g    mat.Matrix
+	}
+	var steps []twoLevel
+	applyTwoLevel := func(s, t int, g mat.Matrix) {
+		for c := 0; c < d; c++ {
+			a, b := w.Data[s][c], w.Data[t][c]
+			w.Data[s][c] = g.At(0, 0)*a + g.At(0, 1)*b
+			w.Data[t][c] = g.At(1, 0)*a + g.At(1, 1)*b
+		}
+		steps = append(steps, twoLevel{s, t, g})
+	}
+	for j := 0; j < d-1; j++ {
+		for i := j + 1; i < d; i++ {
+			b := w.Data[i][j]
+			if cmplx.Abs(b) < 1e-12 {
+				continue
+			}
+			a := w.Data[j][j]
+			n := complex(math.Sqrt(real(a)*real(a)+imag(a)*imag(a)+real(b)*real(b)+imag(b)*imag(b)), 0)
+			applyTwoLevel(j, i, newMatrix2(cmplx.Conj(a)/n, cmplx.Conj(b)/n, -b/n, a/n))
+		}
+		// make the diagonal entry 1
+		if a := w.Data[j][j]; cmplx.Abs(a-1) > 1e-12 {
+			ph := a / complex(cmplx.Abs(a), 0)
+			applyTwoLevel(j, j+1, newMatrix2(cmplx.Conj(ph), 0, 0, ph))
+		}
+	}
+
+	var ops []Operation
+	// u = G1^dagger ... Gm^dagger W, so W is applied first
+	if last := w.Data[d-1][d-1]; cmplx.Abs(last-1) > 1e-12 {
+		ops = append(ops, twoLevelOperations(k, d-2, d-1, newMatrix2(1, 0, 0, last))...)
+	}
+	for i := len(steps) - 1; i >= 0; i-- {
+		ops = append(ops, twoLevelOperations(k, steps[i].s, steps[i].t, conjugateTranspose2(steps[i].g))...)
+	}
+	return ops
+}
+
+/*
+Operations of the unitary which acts as g on the basis states s and t of k qbits and as the identity on the others.
+*/
+func twoLevelOperations(k int, s, t int, g mat.Matrix) []Operation {
+	var diff []int
+	for b := 0; b < k; b++ {
+		if (s^t)&(1<<uint(b)) != 0 {
+			diff = append(diff, b)
+		}
+	}
+	// Gray code from s to the neighbour p of t
+	path := []int{s}
+	for _, b := range diff[:len(diff)-1] {
+		path = append(path, path[len(path)-1]^(1<<uint(b)))
+	}
+	p := path[len(path)-1]
+	last := diff[len(diff)-1]
+
+	var ops []Operation
+	flip := func(from, to int) []Operation {
+		b := from ^ to
+		return patternControlled(k, qbitIndex(uint(b)), from, newMatrix2(0, 1, 1, 0))
+	}
+	for i := 0; i+1 < len(path); i++ {
+		ops = append(ops, flip(path[i], path[i+1])...)
+	}
+	v := g
+	if p&(1<<uint(last)) != 0 {
+		v = newMatrix2(g.At(1, 1), g.At(1, 0), g.At(0, 1), g.At(0, 0))
+	}
+	ops = append(ops, patternControlled(k, last, p, v)...)
+	for i := len(path) - 2; i >= 0; i-- {
+		ops = append(ops, flip(path[i], path[i+1])...)
+	}
+	return ops
+}
+
+/*
+Operations of the single qbit gate u on qbit target, controlled by all other qbits being equal to their bits in pattern.
+*/
+func patternControlled(k int, target int, pattern int, u mat.Matrix) []Operation {
+	var controls []uint
+	var zeros []uint
+	for b := 0; b < k; b++ {
+		if b == target {
+			continue
+		}
+		controls = append(controls, 1<<uint(b))
+		if pattern&(1<<uint(b)) == 0 {
+			zeros = append(zeros, 1<<uint(b))
+		}
+	}
+	var ops []Operation
+	for _, z := range zeros {
+		ops = append(ops, Operation{OpName: OperationTypeNot, TargetQBit: z})
+	}
+	ops = append(ops, exactSingleQBit(1<<uint(target), controls, u)...)
+	for _, z := range zeros {
+		ops = append(ops, Operation{OpName: OperationTypeNot, TargetQBit: z})
+	}
+	return ops
+}
+
+/*
+Operations of the single qbit gate u on target controlled by controls, including the phase of u.
+*/
+func exactSingleQBit(target uint, controls []uint, u mat.Matrix) []Operation {
+	if isNotMatrix(u) {
+		return []Operation{{OpName: OperationTypeNot, TargetQBit: target, ControlQBits: controls}}
+	}
+	theta, phi, lambda, alpha := u3Angles(u)
+	ops := []Operation{{OpName: OperationTypeU3, TargetQBit: target, ControlQBits: controls, Options: []float64{theta, phi, lambda}}}
+	if math.Abs(alpha) < 1e-12 {
+		return ops
+	}
+	if len(controls) > 0 {
+		// e^(i alpha) on the states where all controls are 1
+		var rest []uint
+		if len(controls) > 1 {
+			rest = controls[1:]
+		}
+		return append(ops, Operation{OpName: OperationTypePhase, TargetQBit: controls[0], ControlQBits: rest, Options: []float64{alpha}})
+	}
+	// global phase: P(alpha) X P(alpha) X = e^(i alpha)
+	return append(ops,
+		Operation{OpName: OperationTypePhase, TargetQBit: target, Options: []float64{alpha}},
+		Operation{OpName: OperationTypeNot, TargetQBit: target},
+		Operation{OpName: OperationTypePhase, TargetQBit: target, Options: []float64{alpha}},
+		Operation{OpName: OperationTypeNot, TargetQBit: target})
+}
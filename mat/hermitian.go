@@ -0,0 +1,117 @@
+package mat
+
+import (
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+func (m *Matrix) Mul(b *Matrix) Matrix {
+	c := NewMatrix(m.Rows, b.Cols)
+	var i, j, k uint
+	for i = 0; i < m.Rows; i++ {
+		for k = 0; k < m.Cols; k++ {
+			a := m.At(i, k)
+			if a == 0 {
+				continue
+			}
+			for j = 0; j < b.Cols; j++ {
+				c.Data[i][j] += a * b.At(k, j)
+			}
+		}
+	}
+	return c
+}
+
+func (m *Matrix) ConjTranspose() Matrix {
+	c := NewMatrix(m.Cols, m.Rows)
+	var i, j uint
+	for i = 0; i < m.Rows; i++ {
+		for j = 0; j < m.Cols; j++ {
+			c.Set(j, i, cmplx.Conj(m.At(i, j)))
+		}
+	}
+	return c
+}
+
+func (m *Matrix) Trace() complex128 {
+	var t complex128
+	var i uint
+	for i = 0; i < m.Rows && i < m.Cols; i++ {
+		t += m.At(i, i)
+	}
+	return t
+}
+
+/*
+Eigen decomposition of a Hermitian matrix by the complex Jacobi method.
+
+Return eigenvalues in ascending order and the matrix whose columns are the corresponding eigenvectors.
+*/
+func (m *Matrix) EigenHermitian() ([]float64, Matrix) {
+	n := m.Rows
+	a := m.Copy()
+	v := NewIMatrix(n, n)
+
+	for sweep := 0; sweep < 100; sweep++ {
+		off := 0.0
+		var p, q uint
+		for p = 0; p < n; p++ {
+			for q = p + 1; q < n; q++ {
+				off += cmplx.Abs(a.At(p, q))
+			}
+		}
+		if off < 1e-15 {
+			break
+		}
+
+		for p = 0; p < n; p++ {
+			for q = p + 1; q < n; q++ {
+				b := a.At(p, q)
+				if cmplx.Abs(b) < 1e-300 {
+					continue
+				}
+				// rotate the phase of b away then apply a real Jacobi rotation
+				phase := cmplx.Exp(complex(0, -cmplx.Phase(b)))
+				theta := 0.5 * math.Atan2(2*cmplx.Abs(b), real(a.At(p, p))-real(a.At(q, q)))
+				c := complex(math.Cos(theta), 0)
+				s := complex(math.Sin(theta), 0)
+				g00, g01, g10, g11 := c, -s, phase*s, phase*c
+
+				var k uint
+				for k = 0; k < n; k++ {
+					akp, akq := a.At(k, p), a.At(k, q)
+					a.Set(k, p, akp*g00+akq*g10)
+					a.Set(k, q, akp*g01+akq*g11)
+					vkp, vkq := v.At(k, p), v.At(k, q)
+					v.Set(k, p, vkp*g00+vkq*g10)
+					v.Set(k, q, vkp*g01+vkq*g11)
+				}
+				for k = 0; k < n; k++ {
+					apk, aqk := a.At(p, k), a.At(q, k)
+					a.Set(p, k, cmplx.Conj(g00)*apk+cmplx.Conj(g10)*aqk)
+					a.Set(q, k, cmplx.Conj(g01)*apk+cmplx.Conj(g11)*aqk)
+				}
+			}
+		}
+	}
+
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return real(a.At(uint(idx[i]), uint(idx[i]))) < real(a.At(uint(idx[j]), uint(idx[j])))
+	})
+
+	values := make([]float64, n)
+	vectors := NewMatrix(n, n)
+	for j, k := range idx {
+		values[j] = real(a.At(uint(k), uint(k)))
+		var i uint
+		for i = 0; i < n; i++ {
+			vectors.Set(i, uint(j), v.At(i, uint(k)))
+		}
+	}
+	return values, vectors
+}
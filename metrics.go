@@ -0,0 +1,257 @@
+package goqkit
+
+import (
+	"math"
+)
+
+/*
+Cost figures of a list of operations.
+
+Depths count operations along the longest chain of operations which share qbits.
+Space operations synchronize all qbits without adding depth.
+*/
+type CircuitMetrics struct {
+	//Number of operations, not counting space operations
+	Size int
+	//Depth of the whole circuit
+	Depth int
+	//Depth of the critical path ending on each qbit, indexed by qbit index
+	QBitDepth []int
+	//Depth of the critical path ending on the qbits of each register (only filled by QBitsCircuit.Metrics)
+	RegisterDepth map[string]int
+	//Number of qbits which are acted on by any operation
+	Width int
+	//Number of operations per OperationType
+	GateCounts map[string]int
+	//Number of controlled gates per number of controls
+	ControlCounts map[int]int
+	//Number of gates acting on exactly two qbits
+	TwoQBitGates int
+
+	//Upper bound of the number of T gates after decomposition to Clifford+T (see CliffordTUpperBound)
+	TCount int
+	//Upper bound of the T-depth after decomposition to Clifford+T
+	TDepth int
+	//Number of rotations whose angle is not a multiple of 45 degrees,
+	//which Clifford+T can only approximate and which are not included in TCount
+	NonCliffordTRotations int
+}
+
+/*
+Analyze the operations recorded in this circuit.
+*/
+func (q *QBitsCircuit) Metrics() CircuitMetrics {
+	m := analyzeOperations(q.QBitNumber, q.GetOperations(), q.GateRegistry())
+	m.RegisterDepth = make(map[string]int)
+	for i, reg := range q.qBitRegisters {
+		name := reg.Name
+		if name == "" {
+			name = registerDefaultName(i)
+		}
+		d := 0
+		for _, qb := range q.GetQBits(int(reg.qBits)) {
+			if m.QBitDepth[qbitIndex(qb)] > d {
+				d = m.QBitDepth[qbitIndex(qb)]
+			}
+		}
+		m.RegisterDepth[name] = d
+	}
+	return m
+}
+
+/*
+Analyze operations on n qbits. User defined gates are looked up in DefaultGateRegistry.
+*/
+func AnalyzeOperations(n uint, ops []Operation) CircuitMetrics {
+	return analyzeOperations(n, ops, nil)
+}
+
+func analyzeOperations(n uint, ops []Operation, registry *GateRegistry) CircuitMetrics {
+	m := CircuitMetrics{
+		QBitDepth:     make([]int, n),
+		GateCounts:    make(map[string]int),
+		ControlCounts: make(map[int]int),
+	}
+	tDepth := make([]int, n)
+	used := make([]bool, n)
+
+	for _, op := range ops {
+		if op.OpName == OperationTypeSpace {
+			syncLevels(m.QBitDepth)
+			syncLevels(tDepth)
+			continue
+		}
+		m.Size++
+		m.GateCounts[op.OpName]++
+		if c := len(op.ControlQBits); c > 0 {
+			m.ControlCounts[c]++
+		}
+
+		qbits := op.QBits()
+		if len(qbits) == 2 {
+			m.TwoQBitGates++
+		}
+
+		t, td, approx := cliffordTUpperBound(op, registry)
+		m.TCount += t
+		m.NonCliffordTRotations += approx
+
+		level, tLevel := 0, 0
+		for _, qb := range qbits {
+			i := qbitIndex(qb)
+			used[i] = true
+			if m.QBitDepth[i] > level {
+				level = m.QBitDepth[i]
+			}
+			if tDepth[i] > tLevel {
+				tLevel = tDepth[i]
+			}
+		}
+		for _, qb := range qbits {
+			m.QBitDepth[qbitIndex(qb)] = level + 1
+			tDepth[qbitIndex(qb)] = tLevel + td
+		}
+	}
+
+	for i := range m.QBitDepth {
+		if m.QBitDepth[i] > m.Depth {
+			m.Depth = m.QBitDepth[i]
+		}
+		if tDepth[i] > m.TDepth {
+			m.TDepth = tDepth[i]
+		}
+		if used[i] {
+			m.Width++
+		}
+	}
+	return m
+}
+
+func syncLevels(levels []int) {
+	max := 0
+	for _, l := range levels {
+		if l > max {
+			max = l
+		}
+	}
+	for i := range levels {
+		levels[i] = max
+	}
+}
+
+/*
+Estimate an upper bound of the T-count and T-depth of an operation after decomposition to Clifford+T,
+and return it with the number of rotations which can only be approximated.
+
+The bound comes from fixed textbook decompositions, not from an optimized one, so it overstates
+the cost of gates like controlled phases with several controls, which have cheaper decompositions.
+Rotations by odd multiples of 45 degrees cost one T gate, multiples of 90 degrees are Clifford
+and other angles are returned as approximate rotations instead of T gates.
+Singly controlled phases and rotations are split into three (phase) or two (rotation) half angle rotations and two CNOTs,
+a singly controlled U3 into the six rotations and two CNOTs of its ABC decomposition.
+A Toffoli costs 7 T gates with T-depth 3, a Not or Z with k > 2 controls is a V-chain of 2k-3 Toffolis,
+and any other gate with k > 1 controls computes the AND of the controls with 2(k-1) Toffolis
+around the singly controlled gate. User defined gates are looked up in DefaultGateRegistry.
+*/
+func CliffordTUpperBound(op Operation) (int, int, int) {
+	return cliffordTUpperBound(op, nil)
+}
+
+func cliffordTUpperBound(op Operation, registry *GateRegistry) (int, int, int) {
+	k := len(op.ControlQBits)
+	switch op.OpName {
+	case OperationTypeSpace, OperationTypeRead, OperationTypeWrite, OperationTypeReset:
+		return 0, 0, 0
+	case OperationTypeGate:
+		// T-depth of the expansion is summed, which is an upper bound
+		expanded, err := ExpandOperations([]Operation{op}, registry)
+		if err != nil {
+			return 0, 0, 0
+		}
+		t, td, approx := 0, 0, 0
+		for _, e := range expanded {
+			et, etd, ea := cliffordTUpperBound(e, registry)
+			t, td, approx = t+et, td+etd, approx+ea
+		}
+		return t, td, approx
+	}
+
+	if k > 1 {
+		// a multi controlled Z is a multi controlled Not between two Hadamards
+		if op.OpName == OperationTypeNot || op.OpName == OperationTypeX || op.OpName == OperationTypeZ {
+			toffolis := 2*k - 3
+			return 7 * toffolis, 3 * toffolis, 0
+		}
+		single := op
+		single.ControlQBits = op.ControlQBits[:1]
+		t, td, approx := cliffordTUpperBound(single, registry)
+		toffolis := 2 * (k - 1)
+		return 7*toffolis + t, 3*toffolis + td, approx
+	}
+
+	switch op.OpName {
+	case OperationTypePhase, OperationTypeRotate:
+		deg := optionAt(op, 0)
+		if op.OpName == OperationTypeRotate {
+			_, deg = rotationAxis(op)
+		}
+		if k == 0 {
+			return angleTCost(deg)
+		}
+		parts := 2
+		if op.OpName == OperationTypePhase {
+			parts = 3
+		}
+		t, _, approx := angleTCost(deg / 2)
+		// the half angle rotations on target and control are in parallel
+		td := t
+		if t > 0 {
+			td = 2
+		}
+		return parts * t, td, parts * approx
+	case OperationTypeU3:
+		// U3 = RZ(phi) RY(theta) RZ(lambda) up to a global phase
+		theta, phi, lambda := optionAt(op, 0), optionAt(op, 1), optionAt(op, 2)
+		angles := []float64{theta, phi, lambda}
+		if k == 1 {
+			// ABC decomposition: P((lambda+phi)/2) on the control, RZ((lambda-phi)/2) on the target,
+			// then RZ(-(lambda+phi)/2) RY(-theta/2) and RY(theta/2) RZ(phi) after each of the two CNOTs
+			angles = []float64{(lambda + phi) / 2, (lambda - phi) / 2, (lambda + phi) / 2, theta / 2, theta / 2, phi}
+		}
+		t, td, approx := 0, 0, 0
+		for _, deg := range angles {
+			at, atd, aa := angleTCost(deg)
+			t, td, approx = t+at, td+atd, approx+aa
+		}
+		return t, td, approx
+	case OperationTypeHad:
+		if k == 1 {
+			return 2, 2, 0
+		}
+	case OperationTypeSX:
+		// a controlled SX is a controlled S between two Hadamards
+		if k == 1 {
+			return 3, 2, 0
+		}
+	case OperationTypeSwap:
+		if k == 1 {
+			return 7, 3, 0
+		}
+	}
+	return 0, 0, 0
+}
+
+/*
+Return the Clifford+T cost of a single qbit rotation by deg degrees.
+*/
+func angleTCost(deg float64) (int, int, int) {
+	x := deg / 45
+	r := math.Round(x)
+	if math.Abs(x-r) > 1e-9 {
+		return 0, 0, 1
+	}
+	if int64(r)%2 == 0 {
+		return 0, 0, 0
+	}
+	return 1, 1, 0
+}
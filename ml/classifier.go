@@ -10,11 +10,25 @@ import (
 
 type TrainingStatusHandler func(int, float64, float64, int, int)
 
+type GradientMethod int
+
+const (
+	//Central finite differences (default)
+	GradientFiniteDifference GradientMethod = iota
+	//Exact gradients by the parameter-shift rule
+	GradientParameterShift
+	//Exact gradients by adjoint differentiation of one circuit run
+	GradientAdjoint
+)
+
 type Classifier struct {
 	NumberOfQBits   int
 	NumberOfLayers  int
 	NumberOfClasses int
 
+	//How to compute gradients of the loss in Train
+	GradientMethod GradientMethod
+
 	trainXData [][]float64
 	trainYData [][]float64
 
@@ -32,8 +46,7 @@ func (c *Classifier) SetTrainingStatusHandler(handler TrainingStatusHandler) {
 	c.trainingStatusHandler = handler
 }
 
-func (c *Classifier) Train(opti optimizer.Optimizer, epoch int) {
-
+func (c *Classifier) initTheta() {
 	c.theta = make([][]float64, c.NumberOfLayers)
 
 	for i := 0; i < len(c.theta); i++ {
@@ -42,6 +55,11 @@ func (c *Classifier) Train(opti optimizer.Optimizer, epoch int) {
 			c.theta[i][j] = rand.NormFloat64() * math.Pi * 2
 		}
 	}
+}
+
+func (c *Classifier) Train(opti optimizer.Optimizer, epoch int) {
+
+	c.initTheta()
 
 	lossList := []float64{}
 
@@ -153,6 +171,35 @@ func (c *Classifier) loss(prediction []float64, target []float64) float64 {
 }
 
 func (c *Classifier) gradient(X []float64, Y []float64) [][]float64 {
+	switch c.GradientMethod {
+	case GradientParameterShift:
+		return c.parameterShiftGradient(X, Y)
+	case GradientAdjoint:
+		return c.adjointGradient(X, Y)
+	default:
+		return c.finiteDifferenceGradient(X, Y)
+	}
+}
+
+/*
+Gradient of the cross entropy loss by central finite differences, the default GradientMethod.
+
+The parameters are shifted one after the other and each shift is kept while the
+following parameters are shifted.
+*/
+func (c *Classifier) finiteDifferenceGradient(X []float64, Y []float64) [][]float64 {
+	return c.centralDifferences(X, Y, false)
+}
+
+/*
+Gradient of the cross entropy loss by central finite differences at the current parameters,
+shifting one parameter at a time.
+*/
+func (c *Classifier) centralDifferenceGradient(X []float64, Y []float64) [][]float64 {
+	return c.centralDifferences(X, Y, true)
+}
+
+func (c *Classifier) centralDifferences(X []float64, Y []float64, restore bool) [][]float64 {
 
 	deltaTmp := math.Nextafter(1, 2) - 1
 
@@ -178,11 +225,129 @@ func (c *Classifier) gradient(X []float64, Y []float64) [][]float64 {
 			pred2 := c.quantiumNN(X, dtheta2)
 
 			grad[i] = append(grad[i], (c.crossEntropyLoss(pred, Y)-c.crossEntropyLoss(pred2, Y))/(delta*2))
+
+			if restore {
+				dtheta[i][j] = c.theta[i][j]
+				dtheta2[i][j] = c.theta[i][j]
+			}
 		}
 	}
 	return grad
 }
 
+/*
+Exact gradient of the cross entropy loss by the parameter-shift rule.
+
+Every theta parameter drives exactly one RotY gate, so the derivative of each class probability is
+(p(theta+pi/2) - p(theta-pi/2)) / 2, and the chain rule through crossEntropyLoss gives the loss gradient.
+*/
+func (c *Classifier) parameterShiftGradient(X []float64, Y []float64) [][]float64 {
+
+	pred := c.quantiumNN(X, c.theta)
+	dLoss := c.crossEntropyLossDerivative(pred, Y)
+
+	grad := make([][]float64, len(c.theta))
+	shifted := make([][]float64, len(c.theta))
+	for i := 0; i < len(c.theta); i++ {
+		shifted[i] = dataset.CopyArray(c.theta[i])
+	}
+
+	for i := 0; i < len(c.theta); i++ {
+		grad[i] = make([]float64, len(c.theta[i]))
+		for j := 0; j < len(c.theta[i]); j++ {
+			shifted[i][j] = c.theta[i][j] + math.Pi/2
+			predPlus := c.quantiumNN(X, shifted)
+			shifted[i][j] = c.theta[i][j] - math.Pi/2
+			predMinus := c.quantiumNN(X, shifted)
+			shifted[i][j] = c.theta[i][j]
+
+			for k := 0; k < len(pred); k++ {
+				grad[i][j] += dLoss[k] * (predPlus[k] - predMinus[k]) / 2
+			}
+		}
+	}
+	return grad
+}
+
+/*
+Exact gradient of the cross entropy loss by adjoint differentiation.
+
+The loss derivative is folded into one weighted observable sum_k dL/dp_k * P(qbit k = 1),
+so that a single backward pass gives the gradient of all theta parameters.
+*/
+func (c *Classifier) adjointGradient(X []float64, Y []float64) [][]float64 {
+
+	circuit, _ := c.featureMap(X)
+	c.valiationalCircut(circuit, c.theta)
+
+	pred := make([]float64, c.NumberOfClasses)
+	for i := 0; i < c.NumberOfClasses; i++ {
+		_, pred[i] = circuit.Probability(1 << i)
+	}
+	dLoss := c.crossEntropyLossDerivative(pred, Y)
+
+	obs := make(goqkit.DiagonalObservable, circuit.RawQBits.N)
+	for k := 0; k < c.NumberOfClasses; k++ {
+		for i := range obs {
+			if i&(1<<k) != 0 {
+				obs[i] += dLoss[k]
+			}
+		}
+	}
+
+	g, err := circuit.AdjointGradient(obs)
+	if err != nil {
+		panic(err)
+	}
+
+	//theta parameters are the last RotY gates, ordered by layer and qbit
+	grad := make([][]float64, len(c.theta))
+	offset := len(g) - len(c.theta)*c.NumberOfQBits
+	for i := 0; i < len(c.theta); i++ {
+		grad[i] = make([]float64, len(c.theta[i]))
+		for j := 0; j < len(c.theta[i]); j++ {
+			grad[i][j] = g[offset+i*c.NumberOfQBits+j]
+		}
+	}
+	return grad
+}
+
+/*
+Derivative of crossEntropyLoss with respect to each element of prediction.
+*/
+func (c *Classifier) crossEntropyLossDerivative(prediction []float64, target []float64) []float64 {
+	s := dataset.Sum(prediction)
+	t := dataset.Sum(target)
+	d := make([]float64, len(prediction))
+	for k := 0; k < len(prediction); k++ {
+		d[k] = t / s
+		if target[k] != 0 {
+			d[k] -= target[k] / prediction[k]
+		}
+	}
+	return d
+}
+
+/*
+Compare the configured gradient method against central finite differences
+at the current parameters and return the largest absolute difference.
+*/
+func (c *Classifier) GradientCheck(X []float64, Y []float64) float64 {
+	if c.theta == nil {
+		c.initTheta()
+	}
+	grad := c.gradient(X, Y)
+	ref := c.centralDifferenceGradient(X, Y)
+
+	maxDiff := 0.0
+	for i := 0; i < len(grad); i++ {
+		for j := 0; j < len(grad[i]); j++ {
+			maxDiff = math.Max(maxDiff, math.Abs(grad[i][j]-ref[i][j]))
+		}
+	}
+	return maxDiff
+}
+
 func (c *Classifier) Accuracy(X [][]float64, Y [][]float64) (float64, int, int) {
 	cnt := 0
 	for i := 0; i < len(X); i++ {
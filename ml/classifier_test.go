@@ -0,0 +1,35 @@
+package ml
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestGradientCheck(t *testing.T) {
+	methods := []struct {
+		name   string
+		method GradientMethod
+	}{
+		{"parameter shift", GradientParameterShift},
+		{"adjoint", GradientAdjoint},
+	}
+	X := []float64{0.3, -1.2, 0.8}
+	Y := []float64{0, 1, 0}
+
+	for _, m := range methods {
+		t.Run(m.name, func(t *testing.T) {
+			c := &Classifier{NumberOfQBits: 3, NumberOfLayers: 2, NumberOfClasses: 3, GradientMethod: m.method}
+			rnd := rand.New(rand.NewSource(1))
+			c.theta = make([][]float64, c.NumberOfLayers)
+			for i := range c.theta {
+				c.theta[i] = make([]float64, c.NumberOfQBits)
+				for j := range c.theta[i] {
+					c.theta[i][j] = rnd.NormFloat64() * 2
+				}
+			}
+			if d := c.GradientCheck(X, Y); d > 1e-6 {
+				t.Errorf("gradient differs from finite differences by %g", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package goqkit
+
+import (
+	"github.com/takezo5096/goqkit/mat"
+	"math"
+	"math/cmplx"
+)
+
+/*
+A pass of the optimizer which rewrites a list of operations into an equivalent one.
+*/
+type OptimizationPass func(ops []Operation) []Operation
+
+/*
+Passes which Optimize runs when no pass is given.
+*/
+var DefaultOptimizationPasses = []OptimizationPass{
+	RemoveIdentityRotations,
+	CancelInversePairs,
+	MergeRotations,
+	FuseSingleQBitGates,
+}
+
+/*
+Gate counts before and after optimization.
+*/
+type OptimizationReport struct {
+	Before CircuitMetrics
+	After  CircuitMetrics
+}
+
+/*
+Optimize operations on n qbits by running the passes until the number of operations stops decreasing.
+
+The result implements the same unitary up to a global phase.
+*/
+func Optimize(n uint, ops []Operation, passes ...OptimizationPass) ([]Operation, OptimizationReport) {
+	if len(passes) == 0 {
+		passes = DefaultOptimizationPasses
+	}
+	report := OptimizationReport{Before: AnalyzeOperations(n, ops)}
+
+	optimized := ops
+	for {
+		size := len(optimized)
+		for _, pass := range passes {
+			optimized = pass(optimized)
+		}
+		if len(optimized) >= size {
+			break
+		}
+	}
+
+	report.After = AnalyzeOperations(n, optimized)
+	return optimized, report
+}
+
+/*
+Optimize the operations recorded in this circuit.
+
+The recorded operations and qbits are not changed.
+*/
+func (q *QBitsCircuit) OptimizedOperations(passes ...OptimizationPass) ([]Operation, OptimizationReport) {
+	return Optimize(q.QBitNumber, q.GetOperations(), passes...)
+}
+
+/*
+Return the index of the next operation after i which acts on any of the qbits of ops[i],
+skipping operations which commute with ops[i] when skipCommuting is true.
+
+Return -1 if there is no such operation or a space operation comes first.
+*/
+func nextOperationOn(ops []Operation, removed []bool, i int, skipCommuting bool) int {
+	qbits := make(map[uint]bool)
+	for _, qb := range ops[i].QBits() {
+		qbits[qb] = true
+	}
+	for j := i + 1; j < len(ops); j++ {
+		if removed[j] {
+			continue
+		}
+		if ops[j].OpName == OperationTypeSpace {
+			return -1
+		}
+		shared := false
+		for _, qb := range ops[j].QBits() {
+			if qbits[qb] {
+				shared = true
+				break
+			}
+		}
+		if !shared {
+			continue
+		}
+		if skipCommuting && Commute(ops[i], ops[j]) && !sameQBits(ops[i], ops[j]) {
+			continue
+		}
+		return j
+	}
+	return -1
+}
+
+/*
+Return true if both operations have the same target, controls and swap qbits.
+*/
+func sameQBits(a, b Operation) bool {
+	if a.ControlValue() != b.ControlValue() || len(a.ControlQBits) != len(b.ControlQBits) {
+		return false
+	}
+	if a.OpName == OperationTypeSwap && b.OpName == OperationTypeSwap {
+		return a.TargetQBit|a.SwapQBit == b.TargetQBit|b.SwapQBit
+	}
+	return a.TargetQBit == b.TargetQBit
+}
+
+func compactOperations(ops []Operation, removed []bool) []Operation {
+	result := make([]Operation, 0, len(ops))
+	for i, op := range ops {
+		if !removed[i] {
+			result = append(result, op)
+		}
+	}
+	return result
+}
+
+/*
+Remove pairs of operations which cancel each other, e.g. two Had, two Not or Phase(a) followed by Phase(-a).
+
+Operations in between which commute with the first of the pair are skipped.
+*/
+func CancelInversePairs(ops []Operation) []Operation {
+	removed := make([]bool, len(ops))
+	for i := range ops {
+		if removed[i] || !ops[i].IsUnitary() || ops[i].OpName == OperationTypeSpace {
+			continue
+		}
+		j := nextOperationOn(ops, removed, i, true)
+		if j >= 0 && isInversePair(ops[i], ops[j]) {
+			removed[i] = true
+			removed[j] = true
+		}
+	}
+	return compactOperations(ops, removed)
+}
+
+func isInversePair(a, b Operation) bool {
+	if a.OpName != b.OpName || !sameQBits(a, b) || a.Condition != nil || b.Condition != nil {
+		return false
+	}
+	switch a.OpName {
+	case OperationTypeHad, OperationTypeNot, OperationTypeX, OperationTypeY, OperationTypeZ, OperationTypeSwap:
+		return true
+	case OperationTypePhase:
+		return isIdentityAngle(optionAt(a, 0)+optionAt(b, 0), 360)
+	case OperationTypeRotate:
+		axisA, degA := rotationAxis(a)
+		axisB, degB := rotationAxis(b)
+		return axisA == axisB && isIdentityAngle(degA+degB, rotationPeriod(a))
+	}
+	return false
+}
+
+/*
+Return the angle in degrees after which a rotation is the identity.
+
+A controlled rotation by 360 degrees applies -1 on the controlled subspace, so it needs 720.
+*/
+func rotationPeriod(op Operation) float64 {
+	if len(op.ControlQBits) == 0 {
+		return 360
+	}
+	return 720
+}
+
+func isIdentityAngle(deg float64, period float64) bool {
+	r := math.Mod(deg, period)
+	return math.Abs(r) < 1e-9 || math.Abs(math.Abs(r)-period) < 1e-9
+}
+
+/*
+Merge consecutive Phase gates, or Rotate gates about the same axis, on the same qbits into one gate.
+
+Operations in between which commute with them are skipped.
+*/
+func MergeRotations(ops []Operation) []Operation {
+	result := make([]Operation, len(ops))
+	copy(result, ops)
+	removed := make([]bool, len(ops))
+	for i := range result {
+		if removed[i] || !result[i].IsParametric() || result[i].Condition != nil {
+			continue
+		}
+		for {
+			j := nextOperationOn(result, removed, i, true)
+			if j < 0 || result[j].OpName != result[i].OpName || !sameQBits(result[i], result[j]) || result[j].Condition != nil {
+				break
+			}
+			merged, ok := mergeRotation(result[i], result[j])
+			if !ok {
+				break
+			}
+			// the merged gate takes the place of the later one
+			result[j] = merged
+			removed[i] = true
+			i = j
+		}
+	}
+	return compactOperations(result, removed)
+}
+
+func mergeRotation(a, b Operation) (Operation, bool) {
+	merged := b
+	switch a.OpName {
+	case OperationTypePhase:
+		merged.Options = []float64{optionAt(a, 0) + optionAt(b, 0)}
+	case OperationTypeRotate:
+		axisA, degA := rotationAxis(a)
+		axisB, degB := rotationAxis(b)
+		if axisA != axisB {
+			return b, false
+		}
+		merged.Options = rotateOptions(rotationAxisIndex(a), degA+degB)
+	default:
+		return b, false
+	}
+	return merged, true
+}
+
+/*
+Remove Phase and Rotate gates whose angle makes them the identity, and U3 gates which are the identity up to a global phase.
+*/
+func RemoveIdentityRotations(ops []Operation) []Operation {
+	removed := make([]bool, len(ops))
+	for i, op := range ops {
+		switch op.OpName {
+		case OperationTypePhase:
+			removed[i] = isIdentityAngle(optionAt(op, 0), 360)
+		case OperationTypeRotate:
+			_, deg := rotationAxis(op)
+			removed[i] = isIdentityAngle(deg, rotationPeriod(op))
+		case OperationTypeU3:
+			m, _ := operationMatrix(op)
+			removed[i] = len(op.ControlQBits) == 0 && isIdentityUpToPhase(m)
+		}
+	}
+	return compactOperations(ops, removed)
+}
+
+/*
+Fuse runs of two or more uncontrolled single qbit gates on the same qbit into one U3 gate.
+
+Runs which multiply to the identity up to a global phase are removed.
+*/
+func FuseSingleQBitGates(ops []Operation) []Operation {
+	result := make([]Operation, len(ops))
+	copy(result, ops)
+	removed := make([]bool, len(ops))
+	for i := range result {
+		if removed[i] || !isFusable(result[i]) {
+			continue
+		}
+		run := []int{i}
+		for {
+			j := nextOperationOn(result, removed, run[len(run)-1], false)
+			if j < 0 || !isFusable(result[j]) || result[j].TargetQBit != result[i].TargetQBit {
+				break
+			}
+			run = append(run, j)
+		}
+		if len(run) < 2 {
+			continue
+		}
+
+		u := newMatrix2(1, 0, 0, 1)
+		for _, k := range run {
+			m, _ := operationMatrix(result[k])
+			u = m.Mul(&u)
+			removed[k] = true
+		}
+		if isIdentityUpToPhase(u) {
+			continue
+		}
+		theta, phi, lambda, _ := u3Angles(u)
+		fused := result[run[0]]
+		fused.OpName = OperationTypeU3
+		fused.ControlQBits = nil
+		fused.SwapQBit = 0
+		fused.Options = []float64{theta, phi, lambda}
+		result[run[0]] = fused
+		removed[run[0]] = false
+	}
+	return compactOperations(result, removed)
+}
+
+func isFusable(op Operation) bool {
+	if len(op.ControlQBits) != 0 || op.Condition != nil {
+		return false
+	}
+	switch op.OpName {
+	case OperationTypeHad, OperationTypeNot, OperationTypeX, OperationTypeY, OperationTypeZ,
+		OperationTypeRotate, OperationTypePhase, OperationTypeU3, OperationTypeSX:
+		return true
+	}
+	return false
+}
+
+func isIdentityUpToPhase(m mat.Matrix) bool {
+	const eps = 1e-9
+	return cmplx.Abs(m.At(0, 1)) < eps && cmplx.Abs(m.At(1, 0)) < eps && cmplx.Abs(m.At(0, 0)-m.At(1, 1)) < eps
+}
@@ -0,0 +1,1312 @@
+package goqkit
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Export all recorded operations as an OpenQASM 2.0 program.
+
+See OperationsQASM.
+*/
+func (q *QBitsCircuit) QASM() (string, error) {
+	return q.OperationsQASM(q.GetOperations())
+}
+
+/*
+Export operations as an OpenQASM 2.0 program which includes "qelib1.inc", with angles in radians.
+
+Registers are qreg declarations when they are ranges of qbits in ascending order with QASM identifiers as names,
+otherwise all qbits are one qreg "q". Classical registers are creg declarations, reads without a classical bit
+measure into an extra creg "meas", conditional gates of CIf are prefixed with if, and a Write is a reset followed by x.
+Gates with more controls than the gates of qelib1.inc are decomposed into u3 and cx by Transpile.
+
+User defined gates are gate definitions of their operations, or of their decomposition for gates defined by a matrix.
+The inverse of the gate "name" is defined as "name_dg" and the gate with k controls as "name_ck",
+e.g. "Oracle_dg_c2", which ParseQASM reads back as the inverse and controlled gate operation.
+*/
+func (q *QBitsCircuit) OperationsQASM(ops []Operation) (string, error) {
+	w := &qasmWriter{registry: q.GateRegistry(), defined: make(map[string]bool)}
+
+	used := make(map[string]bool)
+	var cregs []string
+	s := &qasmScope{n: q.QBitNumber, cbits: make(map[uint]string), cregs: make(map[string]bool)}
+	for _, creg := range q.classicalRegisters {
+		if !qasmIdentifier(creg.Name) || used[creg.Name] {
+			return "", fmt.Errorf("classical register %q has no valid QASM name", creg.Name)
+		}
+		used[creg.Name] = true
+		s.cregs[creg.Name] = true
+		cregs = append(cregs, fmt.Sprintf("creg %s[%d];\n", creg.Name, creg.numberOfBits))
+		for i := 0; i < creg.numberOfBits; i++ {
+			s.cbits[1<<uint(creg.shift+i)] = fmt.Sprintf("%s[%d]", creg.Name, i)
+		}
+	}
+	for _, op := range ops {
+		if op.OpName == OperationTypeRead && op.ClassicalBit == 0 && s.meas == "" {
+			s.meas = qasmUniqueName("meas", used)
+			cregs = append(cregs, fmt.Sprintf("creg %s[%d];\n", s.meas, q.QBitNumber))
+		}
+	}
+	qregs, names := q.qasmRegisters(used)
+	s.qbits = names
+	var regNames []string
+	for _, r := range qregs {
+		regNames = append(regNames, r.name)
+	}
+	s.barrier = strings.Join(regNames, ",")
+
+	var body strings.Builder
+	for k, op := range ops {
+		if err := w.operation(&body, s, op); err != nil {
+			return "", fmt.Errorf("operation %d: %v", k, err)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("OPENQASM 2.0;\ninclude \"qelib1.inc\";\n")
+	sb.WriteString(w.defs.String())
+	for _, r := range qregs {
+		sb.WriteString(fmt.Sprintf("qreg %s[%d];\n", r.name, r.size))
+	}
+	sb.WriteString(strings.Join(cregs, ""))
+	sb.WriteString(body.String())
+	return sb.String(), nil
+}
+
+type qasmRegister struct {
+	name string
+	size int
+}
+
+/*
+Return the qreg declarations and the QASM argument of every qbit, e.g. "a[0]".
+
+Qbits which are in no register are declared in extra registers.
+used: names which are taken, the names of the qregs are added
+*/
+func (q *QBitsCircuit) qasmRegisters(used map[string]bool) ([]qasmRegister, []string) {
+	n := int(q.QBitNumber)
+	owner := make([]int, n)
+	for i := range owner {
+		owner[i] = -1
+	}
+	names := make([]string, len(q.qBitRegisters))
+	ok := true
+	taken := make(map[string]bool)
+	for r, reg := range q.qBitRegisters {
+		names[r] = reg.Name
+		if names[r] == "" {
+			names[r] = registerDefaultName(r)
+		}
+		if !qasmIdentifier(names[r]) || used[names[r]] || taken[names[r]] || len(reg.qbitList) == 0 {
+			ok = false
+			break
+		}
+		taken[names[r]] = true
+		first := qbitIndex(reg.qbitList[0])
+		for i, qb := range reg.qbitList {
+			if idx := qbitIndex(qb); idx != first+i || owner[idx] >= 0 {
+				ok = false
+			} else {
+				owner[idx] = r
+			}
+		}
+	}
+
+	args := make([]string, n)
+	var qregs []qasmRegister
+	if !ok {
+		name := qasmUniqueName("q", used)
+		for i := range args {
+			args[i] = fmt.Sprintf("%s[%d]", name, i)
+		}
+		return []qasmRegister{{name, n}}, args
+	}
+	for name := range taken {
+		used[name] = true
+	}
+	for i := 0; i < n; {
+		r := owner[i]
+		size := 0
+		for i+size < n && owner[i+size] == r {
+			size++
+		}
+		name := ""
+		if r >= 0 {
+			name = names[r]
+		} else {
+			name = qasmUniqueName("q", used)
+		}
+		for j := 0; j < size; j++ {
+			args[i+j] = fmt.Sprintf("%s[%d]", name, j)
+		}
+		qregs = append(qregs, qasmRegister{name, size})
+		i += size
+	}
+	return qregs, args
+}
+
+/*
+Return base, or base followed by the smallest number which makes it a name that is not used, and mark it used.
+*/
+func qasmUniqueName(base string, used map[string]bool) string {
+	name := base
+	for i := 1; used[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	used[name] = true
+	return name
+}
+
+type qasmWriter struct {
+	registry *GateRegistry
+	//gate definitions, each after the gates which it uses
+	defs strings.Builder
+	//QASM names of the defined gates
+	defined map[string]bool
+}
+
+/*
+Qbits and classical bits of the program or of a gate definition.
+*/
+type qasmScope struct {
+	n uint
+	//argument of each qbit, e.g. "a[0]" or "q0" in a gate definition
+	qbits []string
+	//argument of each global classical bit
+	cbits map[uint]string
+	//names of the cregs
+	cregs map[string]bool
+	//creg of the reads without a classical bit
+	meas string
+	//arguments of a barrier, "" in a gate definition
+	barrier string
+	//the global phase matters in a gate definition, as the gate may be controlled
+	body bool
+}
+
+func (w *qasmWriter) operation(sb *strings.Builder, s *qasmScope, op Operation) error {
+	prefix := ""
+	if s.body {
+		prefix = "  "
+	}
+	if op.Condition != nil {
+		if !s.cregs[op.Condition.Register] {
+			return fmt.Errorf("condition on the unknown classical register %q", op.Condition.Register)
+		}
+		prefix += fmt.Sprintf("if(%s==%d) ", op.Condition.Register, op.Condition.Value)
+	}
+	line := func(format string, a ...interface{}) {
+		sb.WriteString(prefix + fmt.Sprintf(format, a...) + ";\n")
+	}
+	arg := func(qb uint) string {
+		return s.qbits[qbitIndex(qb)]
+	}
+	args := make([]string, 0, len(op.ControlQBits)+2)
+	for _, c := range op.ControlQBits {
+		args = append(args, arg(c))
+	}
+
+	switch op.OpName {
+	case OperationTypeSpace:
+		if s.barrier != "" {
+			line("barrier %s", s.barrier)
+		}
+		return nil
+	case OperationTypeRead:
+		if op.ClassicalBit != 0 {
+			line("measure %s -> %s", arg(op.TargetQBit), s.cbits[op.ClassicalBit])
+		} else {
+			line("measure %s -> %s[%d]", arg(op.TargetQBit), s.meas, qbitIndex(op.TargetQBit))
+		}
+		return nil
+	case OperationTypeWrite:
+		line("reset %s", arg(op.TargetQBit))
+		line("x %s", arg(op.TargetQBit))
+		return nil
+	case OperationTypeReset:
+		line("reset %s", arg(op.TargetQBit))
+		return nil
+	case OperationTypeGate:
+		name, err := w.gate(op.GateName, len(op.ControlQBits))
+		if err != nil {
+			return err
+		}
+		for _, qb := range op.TargetQBits {
+			args = append(args, arg(qb))
+		}
+		line("%s %s", name, strings.Join(args, ","))
+		return nil
+	}
+
+	name, params, ok := qasmBuiltinGate(op)
+	if !ok {
+		ops, err := w.decompose(s, op)
+		if err != nil {
+			return err
+		}
+		for _, d := range ops {
+			if err := w.operation(sb, s, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	args = append(args, arg(op.TargetQBit))
+	if op.OpName == OperationTypeSwap {
+		args = append(args, arg(op.SwapQBit))
+	}
+	if len(params) > 0 {
+		angles := make([]string, len(params))
+		for i, deg := range params {
+			angles[i] = qasmAngle(deg)
+		}
+		name += "(" + strings.Join(angles, ",") + ")"
+	}
+	line("%s %s", name, strings.Join(args, ","))
+	return nil
+}
+
+/*
+Return the qelib1.inc gate of an operation and its angles in degrees, or false if there is none.
+*/
+func qasmBuiltinGate(op Operation) (string, []float64, bool) {
+	var names []string
+	var params []float64
+	switch op.OpName {
+	case OperationTypeNot, OperationTypeX:
+		names = []string{"x", "cx", "ccx"}
+	case OperationTypeY:
+		names = []string{"y", "cy"}
+	case OperationTypeZ:
+		names = []string{"z", "cz"}
+	case OperationTypeHad:
+		names = []string{"h", "ch"}
+	case OperationTypeSX:
+		names = []string{"sx", "csx"}
+	case OperationTypeSwap:
+		names = []string{"swap", "cswap"}
+	case OperationTypePhase:
+		names = []string{"p", "cp"}
+		params = []float64{optionAt(op, 0)}
+	case OperationTypeRotate:
+		axis, deg := rotationAxis(op)
+		axis = strings.ToLower(axis)
+		names = []string{"r" + axis, "cr" + axis}
+		params = []float64{deg}
+	case OperationTypeU3:
+		names = []string{"u3", "cu3"}
+		params = []float64{optionAt(op, 0), optionAt(op, 1), optionAt(op, 2)}
+	}
+	if len(op.ControlQBits) >= len(names) {
+		return "", nil, false
+	}
+	return names[len(op.ControlQBits)], params, true
+}
+
+/*
+Decompose an operation which has no qelib1.inc gate into u3 and cx.
+*/
+func (w *qasmWriter) decompose(s *qasmScope, op Operation) ([]Operation, error) {
+	ops, err := Transpile(s.n, []Operation{op}, TranspileOptions{Basis: []string{BasisCX, BasisU3}, Gates: w.registry})
+	if err != nil || !s.body {
+		return ops, err
+	}
+	// Transpile is exact up to a global phase, which is restored by P(alpha) X P(alpha) X
+	u, err := operationsUnitary(s.n, []Operation{op}, w.registry)
+	if err != nil {
+		return nil, err
+	}
+	v, err := operationsUnitary(s.n, ops, w.registry)
+	if err != nil {
+		return nil, err
+	}
+	i, j := 0, 0
+	for r := range u.Data {
+		for c := range u.Data[r] {
+			if cmplx.Abs(u.Data[r][c]) > cmplx.Abs(u.Data[i][j]) {
+				i, j = r, c
+			}
+		}
+	}
+	alpha := cmplx.Phase(u.Data[i][j]/v.Data[i][j]) * 180 / math.Pi
+	if math.Abs(alpha) > 1e-9 {
+		p := Operation{OpName: OperationTypePhase, TargetQBit: op.TargetQBit, Options: []float64{alpha}}
+		x := Operation{OpName: OperationTypeX, TargetQBit: op.TargetQBit}
+		ops = append(ops, p, x, p, x)
+	}
+	return ops, nil
+}
+
+/*
+Define a user defined gate with controls in the program, after the gates which it uses, and return its QASM name.
+*/
+func (w *qasmWriter) gate(name string, controls int) (string, error) {
+	base := strings.TrimSuffix(name, GateInverseSuffix)
+	if !qasmIdentifier(base) || qasmDerivedGateName(base) != nil {
+		return "", fmt.Errorf("gate %q has no valid QASM name", base)
+	}
+	qname := base
+	if base != name {
+		qname += "_dg"
+	}
+	if controls > 0 {
+		qname += fmt.Sprintf("_c%d", controls)
+	}
+	if w.defined[qname] {
+		return qname, nil
+	}
+
+	def, ok := w.registry.Lookup(name)
+	if !ok {
+		return "", fmt.Errorf("gate %q is not defined", name)
+	}
+	ops := def.Operations
+	if def.Matrix != nil {
+		expanded, err := def.Expand()
+		if err != nil {
+			return "", err
+		}
+		ops = expanded
+	}
+	n := controls + def.NumberOfQBits
+	targets := make([]uint, def.NumberOfQBits)
+	for i := range targets {
+		targets[i] = 1 << uint(controls+i)
+	}
+	ops, err := mapQBits(ops, nil, targets, (1<<uint(controls))-1)
+	if err != nil {
+		return "", fmt.Errorf("gate %q: %v", name, err)
+	}
+
+	params := make([]string, n)
+	for i := range params {
+		params[i] = fmt.Sprintf("q%d", i)
+	}
+	s := &qasmScope{n: uint(n), qbits: params, body: true}
+	var body strings.Builder
+	for k, op := range ops {
+		if err := w.operation(&body, s, op); err != nil {
+			return "", fmt.Errorf("gate %q: operation %d: %v", name, k, err)
+		}
+	}
+	w.defined[qname] = true
+	w.defs.WriteString(fmt.Sprintf("gate %s %s {\n%s}\n", qname, strings.Join(params, ","), body.String()))
+	return qname, nil
+}
+
+/*
+Format an angle given in degrees in radians, as a multiple of pi when it is a simple fraction of pi.
+*/
+func qasmAngle(deg float64) string {
+	x := deg / 180
+	for den := 1; den <= 64; den++ {
+		num := x * float64(den)
+		if math.Abs(num-math.Round(num)) > 1e-9 {
+			continue
+		}
+		p := int(math.Round(num))
+		sign := ""
+		if p < 0 {
+			sign = "-"
+			p = -p
+		}
+		switch {
+		case p == 0:
+			return "0"
+		case den == 1 && p == 1:
+			return sign + "pi"
+		case den == 1:
+			return fmt.Sprintf("%s%d*pi", sign, p)
+		case p == 1:
+			return fmt.Sprintf("%spi/%d", sign, den)
+		}
+		return fmt.Sprintf("%s%d*pi/%d", sign, p, den)
+	}
+	return strconv.FormatFloat(deg*math.Pi/180, 'g', -1, 64)
+}
+
+var qasmIdentifierPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+/*
+Names which a QASM program can not declare: keywords and the gates of qelib1.inc.
+*/
+var qasmReservedNames = map[string]bool{
+	"OPENQASM": true, "include": true, "qreg": true, "creg": true, "gate": true, "opaque": true, "measure": true,
+	"reset": true, "barrier": true, "if": true, "pi": true,
+	"sin": true, "cos": true, "tan": true, "exp": true, "ln": true, "sqrt": true,
+}
+
+func qasmIdentifier(name string) bool {
+	_, builtin := qasmGates[name]
+	return qasmIdentifierPattern.MatchString(name) && !qasmReservedNames[name] && !builtin
+}
+
+var qasmDerivedGatePattern = regexp.MustCompile(`^(.+?)(_dg)?(?:_c([1-9][0-9]*))?$`)
+
+/*
+Split the name of an inverse or controlled gate, e.g. "Oracle_dg_c2", into the base name,
+whether it is the inverse and the number of controls. Return nil for other names.
+*/
+func qasmDerivedGateName(name string) *qasmGate {
+	m := qasmDerivedGatePattern.FindStringSubmatch(name)
+	if m == nil || m[2] == "" && m[3] == "" {
+		return nil
+	}
+	g := &qasmGate{name: m[1]}
+	if m[2] != "" {
+		g.name += GateInverseSuffix
+	}
+	if m[3] != "" {
+		g.controls, _ = strconv.Atoi(m[3])
+	}
+	return g
+}
+
+/*
+A gate of a QASM program.
+*/
+type qasmGate struct {
+	//number of angles and qbits
+	params, qbits int
+	//operations of a qelib1.inc gate, angles in degrees and the controls first in qbits
+	operations func(params []float64, qbits []uint) []Operation
+
+	//name of a user defined gate, with GateInverseSuffix for its inverse
+	name string
+	//number of controls of a user defined gate, which are its first qbits
+	controls int
+}
+
+/*
+Make an operation of a qelib1.inc gate whose first controls qbits are the controls.
+*/
+func qasmOperation(opName string, qbits []uint, controls int, options ...float64) Operation {
+	op := Operation{OpName: opName, TargetQBit: qbits[controls], Options: options}
+	if controls > 0 {
+		op.ControlQBits = append([]uint{}, qbits[:controls]...)
+		sort.Slice(op.ControlQBits, func(i, j int) bool { return op.ControlQBits[i] < op.ControlQBits[j] })
+	}
+	if opName == OperationTypeSwap {
+		op.SwapQBit = qbits[controls+1]
+	}
+	return op
+}
+
+func qasmFixed(opName string, qbits, controls int, options ...float64) qasmGate {
+	return qasmGate{qbits: qbits, operations: func(_ []float64, q []uint) []Operation {
+		return []Operation{qasmOperation(opName, q, controls, options...)}
+	}}
+}
+
+func qasmPhase(controls int) qasmGate {
+	return qasmGate{params: 1, qbits: controls + 1, operations: func(p []float64, q []uint) []Operation {
+		return []Operation{qasmOperation(OperationTypePhase, q, controls, p[0])}
+	}}
+}
+
+func qasmRotation(axis, controls int) qasmGate {
+	return qasmGate{params: 1, qbits: controls + 1, operations: func(p []float64, q []uint) []Operation {
+		op := qasmOperation(OperationTypeRotate, q, controls, rotateOptions(axis, p[0])...)
+		op.Axis = rotationAxes[axis]
+		return []Operation{op}
+	}}
+}
+
+func qasmU3(controls int) qasmGate {
+	return qasmGate{params: 3, qbits: controls + 1, operations: func(p []float64, q []uint) []Operation {
+		return []Operation{qasmOperation(OperationTypeU3, q, controls, p...)}
+	}}
+}
+
+/*
+The gates of qelib1.inc and the built in U and CX.
+*/
+var qasmGates = map[string]qasmGate{
+	"U":   qasmU3(0),
+	"u3":  qasmU3(0),
+	"u":   qasmU3(0),
+	"cu3": qasmU3(1),
+	"u2": {params: 2, qbits: 1, operations: func(p []float64, q []uint) []Operation {
+		return []Operation{qasmOperation(OperationTypeU3, q, 0, 90, p[0], p[1])}
+	}},
+	"u1":  qasmPhase(0),
+	"p":   qasmPhase(0),
+	"cu1": qasmPhase(1),
+	"cp":  qasmPhase(1),
+	"rx":  qasmRotation(0, 0),
+	"ry":  qasmRotation(1, 0),
+	"rz":  qasmRotation(2, 0),
+	"crx": qasmRotation(0, 1),
+	"cry": qasmRotation(1, 1),
+	"crz": qasmRotation(2, 1),
+	"x":   qasmFixed(OperationTypeNot, 1, 0),
+	"CX":  qasmFixed(OperationTypeNot, 2, 1),
+	"cx":  qasmFixed(OperationTypeNot, 2, 1),
+	"ccx": qasmFixed(OperationTypeNot, 3, 2),
+	"y":   qasmFixed(OperationTypeY, 1, 0),
+	"cy":  qasmFixed(OperationTypeY, 2, 1),
+	"z":   qasmFixed(OperationTypeZ, 1, 0),
+	"cz":  qasmFixed(OperationTypeZ, 2, 1),
+	"h":   qasmFixed(OperationTypeHad, 1, 0),
+	"ch":  qasmFixed(OperationTypeHad, 2, 1),
+	"sx":  qasmFixed(OperationTypeSX, 1, 0),
+	"csx": qasmFixed(OperationTypeSX, 2, 1),
+	// SX^3 is the inverse of SX including the global phase
+	"sxdg": {qbits: 1, operations: func(_ []float64, q []uint) []Operation {
+		sx := qasmOperation(OperationTypeSX, q, 0)
+		return []Operation{sx, sx, sx}
+	}},
+	"s":     qasmFixed(OperationTypePhase, 1, 0, 90),
+	"sdg":   qasmFixed(OperationTypePhase, 1, 0, -90),
+	"t":     qasmFixed(OperationTypePhase, 1, 0, 45),
+	"tdg":   qasmFixed(OperationTypePhase, 1, 0, -45),
+	"swap":  qasmFixed(OperationTypeSwap, 2, 0),
+	"cswap": qasmFixed(OperationTypeSwap, 3, 1),
+	"id": {qbits: 1, operations: func(_ []float64, _ []uint) []Operation {
+		return nil
+	}},
+}
+
+/*
+Parse an OpenQASM 2.0 program, e.g. of OperationsQASM, into the format of a dump.
+
+The dump has the qregs as registers, the cregs as classical registers, the qbits in |0>
+and the gate definitions, so that CircuitFromDump and Replay run the program.
+Measurements are reads into classical bits, barriers are space operations, and angles are converted to degrees.
+The gates of qelib1.inc are supported, and gate definitions without parameters are user defined gates,
+except the inverse and controlled gates named like OperationsQASM does, which become operations of their gate.
+
+registry: registry where the gates of the program are defined, nothing is defined when nil.
+A gate which is already defined is skipped if it has the same unitary.
+*/
+func ParseQASM(data []byte, registry *GateRegistry) (DumpFormat, error) {
+	tokens, err := tokenizeQASM(string(data))
+	if err != nil {
+		return DumpFormat{}, err
+	}
+	p := &qasmParser{tokens: tokens, qregs: make(map[string][]uint), cregs: make(map[string][]uint), gates: make(map[string]qasmGate)}
+	if err := p.program(); err != nil {
+		return DumpFormat{}, err
+	}
+	p.df.QBits = make([][]float64, 1<<p.n)
+	for i := range p.df.QBits {
+		p.df.QBits[i] = []float64{0, 0}
+	}
+	p.df.QBits[0][0] = 1
+	if registry != nil {
+		if err := registry.defineQASMGates(p.df.Gates); err != nil {
+			return DumpFormat{}, err
+		}
+	}
+	return p.df, nil
+}
+
+/*
+Define the gates of a QASM program.
+
+A gate which is already defined is skipped if it has the same unitary,
+since a gate which was defined by a matrix is exported as its decomposition.
+*/
+func (r *GateRegistry) defineQASMGates(gates []DumpFormatGate) error {
+	for _, g := range gates {
+		if def, ok := r.Lookup(g.Name); ok {
+			u, err := def.Unitary()
+			if err != nil {
+				return err
+			}
+			v, err := operationsUnitary(uint(g.NumberOfQBits), g.Operations, r)
+			if err != nil {
+				return fmt.Errorf("gate %q: %v", g.Name, err)
+			}
+			if def.NumberOfQBits != g.NumberOfQBits || !matricesEqual(&u, &v, false) {
+				return fmt.Errorf("gate %q is already defined differently", g.Name)
+			}
+			continue
+		}
+		if err := r.DefineOperations(g.Name, g.NumberOfQBits, g.Operations); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type qasmToken struct {
+	text string
+	line int
+}
+
+/*
+Split a QASM program into identifiers, numbers, strings and symbols, without the comments.
+*/
+func tokenizeQASM(src string) ([]qasmToken, error) {
+	var tokens []qasmToken
+	line := 1
+	isLetter := func(c byte) bool {
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+	}
+	isDigit := func(c byte) bool {
+		return c >= '0' && c <= '9'
+	}
+	for i := 0; i < len(src); {
+		c := src[i]
+		start := i
+		switch {
+		case c == '\n':
+			line++
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+			continue
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		case isLetter(c):
+			for i < len(src) && (isLetter(src[i]) || isDigit(src[i])) {
+				i++
+			}
+		case isDigit(c) || c == '.' && i+1 < len(src) && isDigit(src[i+1]):
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.') {
+				i++
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+		case c == '"':
+			i++
+			for i < len(src) && src[i] != '"' && src[i] != '\n' {
+				i++
+			}
+			if i == len(src) || src[i] != '"' {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			i++
+		case strings.HasPrefix(src[i:], "->") || strings.HasPrefix(src[i:], "=="):
+			i += 2
+		case strings.IndexByte(";,()[]{}+-*/^", c) >= 0:
+			i++
+		default:
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+		}
+		tokens = append(tokens, qasmToken{text: src[start:i], line: line})
+	}
+	return tokens, nil
+}
+
+type qasmParser struct {
+	tokens []qasmToken
+	pos    int
+
+	df DumpFormat
+	//number of qbits
+	n uint
+	//global qbits of each qreg
+	qregs map[string][]uint
+	//global classical bits of each creg
+	cregs map[string][]uint
+	//user defined gates
+	gates map[string]qasmGate
+}
+
+func (p *qasmParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos].text
+}
+
+func (p *qasmParser) line() int {
+	if p.pos >= len(p.tokens) {
+		if len(p.tokens) == 0 {
+			return 1
+		}
+		return p.tokens[len(p.tokens)-1].line
+	}
+	return p.tokens[p.pos].line
+}
+
+func (p *qasmParser) next() string {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *qasmParser) accept(text string) bool {
+	if p.peek() == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *qasmParser) expect(text string) error {
+	if t := p.next(); t != text {
+		return fmt.Errorf("expected %q, got %q", text, t)
+	}
+	return nil
+}
+
+func (p *qasmParser) identifier() (string, error) {
+	t := p.next()
+	if !qasmIdentifierPattern.MatchString(t) && !strings.HasPrefix(t, "_") {
+		return "", fmt.Errorf("expected an identifier, got %q", t)
+	}
+	return t, nil
+}
+
+func (p *qasmParser) integer() (int, error) {
+	t := p.next()
+	v, err := strconv.Atoi(t)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("expected a non-negative integer, got %q", t)
+	}
+	return v, nil
+}
+
+func (p *qasmParser) program() error {
+	if p.accept("OPENQASM") {
+		if v := p.next(); !strings.HasPrefix(v, "2") {
+			return fmt.Errorf("line %d: OpenQASM version %s is not supported", p.line(), v)
+		}
+		if err := p.expect(";"); err != nil {
+			return fmt.Errorf("line %d: %v", p.line(), err)
+		}
+	}
+	for p.pos < len(p.tokens) {
+		line := p.line()
+		if err := p.statement(); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return nil
+}
+
+func (p *qasmParser) statement() error {
+	switch p.peek() {
+	case "include":
+		p.next()
+		if file := p.next(); file != `"qelib1.inc"` {
+			return fmt.Errorf("include of %s is not supported", file)
+		}
+		return p.expect(";")
+	case "qreg", "creg":
+		kind := p.next()
+		name, err := p.identifier()
+		if err != nil {
+			return err
+		}
+		if _, ok := p.qregs[name]; ok {
+			return fmt.Errorf("register %q is already declared", name)
+		}
+		if _, ok := p.cregs[name]; ok {
+			return fmt.Errorf("register %q is already declared", name)
+		}
+		if err := p.expect("["); err != nil {
+			return err
+		}
+		size, err := p.integer()
+		if err != nil {
+			return err
+		}
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+		if err := p.expect(";"); err != nil {
+			return err
+		}
+		if kind == "qreg" {
+			p.declareQReg(name, size)
+		} else {
+			p.declareCReg(name, size)
+		}
+		return nil
+	case "gate":
+		p.next()
+		return p.gateDefinition()
+	case "opaque":
+		return fmt.Errorf("opaque gates are not supported")
+	case "if":
+		p.next()
+		if err := p.expect("("); err != nil {
+			return err
+		}
+		name, err := p.identifier()
+		if err != nil {
+			return err
+		}
+		bits, ok := p.cregs[name]
+		if !ok {
+			return fmt.Errorf("unknown creg %q", name)
+		}
+		if err := p.expect("=="); err != nil {
+			return err
+		}
+		value, err := p.integer()
+		if err != nil {
+			return err
+		}
+		if err := p.expect(")"); err != nil {
+			return err
+		}
+		var mask uint
+		for _, b := range bits {
+			mask |= b
+		}
+		return p.quantumOperation(&Condition{Register: name, Bits: mask, Value: value})
+	}
+	return p.quantumOperation(nil)
+}
+
+func (p *qasmParser) declareQReg(name string, size int) {
+	reg := DumpFormatRegister{NumberOfQBits: size, Shift: int(p.n), Name: name}
+	for i := 0; i < size; i++ {
+		reg.QBits = append(reg.QBits, 1<<(p.n+uint(i)))
+	}
+	p.qregs[name] = reg.QBits
+	p.df.Registers = append(p.df.Registers, reg)
+	p.n += uint(size)
+}
+
+func (p *qasmParser) declareCReg(name string, size int) {
+	shift := 0
+	for _, r := range p.df.ClassicalRegisters {
+		shift += r.NumberOfBits
+	}
+	creg := DumpFormatClassicalRegister{NumberOfBits: size, Bits: ((1 << uint(size)) - 1) << uint(shift), Shift: shift, Name: name}
+	bits := make([]uint, size)
+	for i := range bits {
+		bits[i] = 1 << uint(shift+i)
+	}
+	p.cregs[name] = bits
+	p.df.ClassicalRegisters = append(p.df.ClassicalRegisters, creg)
+}
+
+/*
+Parse an argument, a whole register or one bit of it, into global bit values.
+*/
+func (p *qasmParser) argument(regs map[string][]uint) ([]uint, error) {
+	name, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	bits, ok := regs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown register %q", name)
+	}
+	if !p.accept("[") {
+		return bits, nil
+	}
+	i, err := p.integer()
+	if err != nil {
+		return nil, err
+	}
+	if i >= len(bits) {
+		return nil, fmt.Errorf("index %d is out of register %q of size %d", i, name, len(bits))
+	}
+	return []uint{bits[i]}, p.expect("]")
+}
+
+/*
+Parse the arguments of a statement up to ";" and return the bits of each application,
+where whole registers are applied bit by bit.
+*/
+func (p *qasmParser) arguments() ([][]uint, error) {
+	var args [][]uint
+	for {
+		a, err := p.argument(p.qregs)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	return broadcastQASM(args)
+}
+
+func broadcastQASM(args [][]uint) ([][]uint, error) {
+	size := 1
+	for _, a := range args {
+		if len(a) != 1 {
+			if size != 1 && len(a) != size {
+				return nil, fmt.Errorf("registers of different sizes %d and %d", size, len(a))
+			}
+			size = len(a)
+		}
+	}
+	apps := make([][]uint, size)
+	for i := range apps {
+		for _, a := range args {
+			if len(a) == 1 {
+				apps[i] = append(apps[i], a[0])
+			} else {
+				apps[i] = append(apps[i], a[i])
+			}
+		}
+	}
+	return apps, nil
+}
+
+func (p *qasmParser) quantumOperation(cond *Condition) error {
+	var ops []Operation
+	switch p.peek() {
+	case "measure":
+		p.next()
+		qbits, err := p.argument(p.qregs)
+		if err != nil {
+			return err
+		}
+		if err := p.expect("->"); err != nil {
+			return err
+		}
+		cbits, err := p.argument(p.cregs)
+		if err != nil {
+			return err
+		}
+		if err := p.expect(";"); err != nil {
+			return err
+		}
+		if len(qbits) != len(cbits) {
+			return fmt.Errorf("measure of %d qbits into %d classical bits", len(qbits), len(cbits))
+		}
+		for i := range qbits {
+			ops = append(ops, Operation{OpName: OperationTypeRead, TargetQBit: qbits[i], ClassicalBit: cbits[i]})
+		}
+	case "reset":
+		p.next()
+		apps, err := p.arguments()
+		if err != nil {
+			return err
+		}
+		for _, a := range apps {
+			ops = append(ops, Operation{OpName: OperationTypeReset, TargetQBit: a[0]})
+		}
+	case "barrier":
+		p.next()
+		if _, err := p.arguments(); err != nil {
+			return err
+		}
+		ops = append(ops, Operation{OpName: OperationTypeSpace})
+	default:
+		name, err := p.identifier()
+		if err != nil {
+			return err
+		}
+		params, err := p.parameters()
+		if err != nil {
+			return err
+		}
+		apps, err := p.arguments()
+		if err != nil {
+			return err
+		}
+		for _, a := range apps {
+			gops, err := p.gateOperations(name, params, a)
+			if err != nil {
+				return err
+			}
+			ops = append(ops, gops...)
+		}
+	}
+
+	for _, op := range ops {
+		op.Condition = cond
+		qb := op.TargetQBit
+		if op.OpName == OperationTypeSpace {
+			qb = 1
+		}
+		for _, reg := range p.df.Registers {
+			for _, b := range reg.QBits {
+				if b == qb {
+					op.RegisterName = 1 << uint(reg.Shift)
+					op.RegisterNameString = reg.Name
+				}
+			}
+		}
+		p.df.Operations = append(p.df.Operations, op)
+	}
+	return nil
+}
+
+/*
+Parse the angles of a gate, if any, in degrees.
+*/
+func (p *qasmParser) parameters() ([]float64, error) {
+	if !p.accept("(") {
+		return nil, nil
+	}
+	var params []float64
+	if p.accept(")") {
+		return nil, nil
+	}
+	for {
+		rad, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		deg := rad * 180 / math.Pi
+		// undo the rounding of the conversion to radians, e.g. 29.999999999999996 for pi/6
+		if r := math.Round(deg*1e6) / 1e6; math.Abs(deg-r) < 1e-9 {
+			deg = r
+		}
+		params = append(params, deg)
+		if !p.accept(",") {
+			break
+		}
+	}
+	return params, p.expect(")")
+}
+
+/*
+Return the operations of applying a gate to qbits.
+*/
+func (p *qasmParser) gateOperations(name string, params []float64, qbits []uint) ([]Operation, error) {
+	for i := range qbits {
+		for j := 0; j < i; j++ {
+			if qbits[i] == qbits[j] {
+				return nil, fmt.Errorf("gate %q is applied twice to qbit %d", name, qbitIndex(qbits[i]))
+			}
+		}
+	}
+	g, ok := qasmGates[name]
+	if !ok {
+		if g, ok = p.gates[name]; !ok {
+			return nil, fmt.Errorf("gate %q is not defined", name)
+		}
+	}
+	if len(params) != g.params || len(qbits) != g.qbits {
+		return nil, fmt.Errorf("gate %q takes %d angles and %d qbits, got %d and %d", name, g.params, g.qbits, len(params), len(qbits))
+	}
+	if g.operations != nil {
+		return g.operations(params, qbits), nil
+	}
+	op := qasmOperation(OperationTypeGate, qbits, g.controls)
+	op.GateName = g.name
+	op.TargetQBits = append([]uint{}, qbits[g.controls:]...)
+	return []Operation{op}, nil
+}
+
+func (p *qasmParser) gateDefinition() error {
+	name, err := p.identifier()
+	if err != nil {
+		return err
+	}
+	if _, ok := p.gates[name]; ok || !qasmIdentifier(name) {
+		return fmt.Errorf("gate %q can not be defined", name)
+	}
+	if p.accept("(") && !p.accept(")") {
+		return fmt.Errorf("gate %q: gates with parameters are not supported", name)
+	}
+	var params []string
+	local := make(map[string][]uint)
+	for {
+		param, err := p.identifier()
+		if err != nil {
+			return err
+		}
+		if _, ok := local[param]; ok {
+			return fmt.Errorf("gate %q: qbit %q is declared twice", name, param)
+		}
+		local[param] = []uint{1 << uint(len(params))}
+		params = append(params, param)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+
+	// inverse and controlled gates of OperationsQASM are operations of the gate
+	if d := qasmDerivedGateName(name); d != nil {
+		if base, ok := p.gates[strings.TrimSuffix(d.name, GateInverseSuffix)]; ok && base.controls == 0 && base.name == strings.TrimSuffix(d.name, GateInverseSuffix) {
+			if len(params) != d.controls+base.qbits {
+				return fmt.Errorf("gate %q has %d qbits, expected %d", name, len(params), d.controls+base.qbits)
+			}
+			for p.peek() != "}" {
+				if p.next() == "" {
+					return fmt.Errorf("gate %q: expected \"}\"", name)
+				}
+			}
+			p.next()
+			p.gates[name] = qasmGate{qbits: len(params), name: d.name, controls: d.controls}
+			return nil
+		}
+	}
+
+	var ops []Operation
+	for !p.accept("}") {
+		if p.peek() == "" {
+			return fmt.Errorf("gate %q: expected \"}\"", name)
+		}
+		if p.accept("barrier") {
+			for p.peek() != ";" && p.peek() != "" {
+				p.next()
+			}
+			if err := p.expect(";"); err != nil {
+				return err
+			}
+			continue
+		}
+		callee, err := p.identifier()
+		if err != nil {
+			return err
+		}
+		angles, err := p.parameters()
+		if err != nil {
+			return err
+		}
+		var qbits []uint
+		for {
+			a, err := p.argument(local)
+			if err != nil {
+				return fmt.Errorf("gate %q: %v", name, err)
+			}
+			qbits = append(qbits, a...)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(";"); err != nil {
+			return err
+		}
+		gops, err := p.gateOperations(callee, angles, qbits)
+		if err != nil {
+			return fmt.Errorf("gate %q: %v", name, err)
+		}
+		ops = append(ops, gops...)
+	}
+	p.gates[name] = qasmGate{qbits: len(params), name: name}
+	p.df.Gates = append(p.df.Gates, DumpFormatGate{Name: name, NumberOfQBits: len(params), Operations: ops})
+	return nil
+}
+
+/*
+Parse an arithmetic expression of numbers and pi, e.g. "-3*pi/4", and return its value.
+*/
+func (p *qasmParser) expression() (float64, error) {
+	v, err := p.term()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch {
+		case p.accept("+"):
+			t, err := p.term()
+			if err != nil {
+				return 0, err
+			}
+			v += t
+		case p.accept("-"):
+			t, err := p.term()
+			if err != nil {
+				return 0, err
+			}
+			v -= t
+		default:
+			return v, nil
+		}
+	}
+}
+
+func (p *qasmParser) term() (float64, error) {
+	v, err := p.factor()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch {
+		case p.accept("*"):
+			f, err := p.factor()
+			if err != nil {
+				return 0, err
+			}
+			v *= f
+		case p.accept("/"):
+			f, err := p.factor()
+			if err != nil {
+				return 0, err
+			}
+			v /= f
+		default:
+			return v, nil
+		}
+	}
+}
+
+func (p *qasmParser) factor() (float64, error) {
+	if p.accept("-") {
+		v, err := p.factor()
+		return -v, err
+	}
+	if p.accept("+") {
+		return p.factor()
+	}
+	v, err := p.primary()
+	if err != nil {
+		return 0, err
+	}
+	if p.accept("^") {
+		e, err := p.factor()
+		if err != nil {
+			return 0, err
+		}
+		v = math.Pow(v, e)
+	}
+	return v, nil
+}
+
+var qasmFunctions = map[string]func(float64) float64{
+	"sin": math.Sin, "cos": math.Cos, "tan": math.Tan, "exp": math.Exp, "ln": math.Log, "sqrt": math.Sqrt,
+}
+
+func (p *qasmParser) primary() (float64, error) {
+	t := p.next()
+	if t == "(" {
+		v, err := p.expression()
+		if err != nil {
+			return 0, err
+		}
+		return v, p.expect(")")
+	}
+	if t == "pi" {
+		return math.Pi, nil
+	}
+	if f, ok := qasmFunctions[t]; ok {
+		if err := p.expect("("); err != nil {
+			return 0, err
+		}
+		v, err := p.expression()
+		if err != nil {
+			return 0, err
+		}
+		return f(v), p.expect(")")
+	}
+	v, err := strconv.ParseFloat(t, 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected a number, got %q", t)
+	}
+	return v, nil
+}
@@ -0,0 +1,251 @@
+package goqkit_test
+
+import (
+	"github.com/takezo5096/goqkit"
+	"github.com/takezo5096/goqkit/mat"
+	"github.com/takezo5096/goqkit/qtest"
+	"strings"
+	"testing"
+)
+
+/*
+A registry with the matrix gate "iswap" and the gate "Bell" of operations, which uses "iswap".
+*/
+func qasmTestGates(t *testing.T) *goqkit.GateRegistry {
+	t.Helper()
+	registry := goqkit.NewGateRegistry()
+	iswap := mat.NewMatrix(4, 4)
+	iswap.Set(0, 0, 1)
+	iswap.Set(1, 2, complex(0, 1))
+	iswap.Set(2, 1, complex(0, 1))
+	iswap.Set(3, 3, 1)
+	if err := registry.DefineMatrix("iswap", iswap); err != nil {
+		t.Fatal(err)
+	}
+
+	c := goqkit.MakeQBitsCircuit(3)
+	c.SetGateRegistry(registry)
+	c.AssignQBits(3, "q")
+	sub, err := c.Capture(func() {
+		c.Had(1, 0)
+		c.Not(2, 1)
+		c.Phase(2, 0, 30)
+		c.RotY(4, 3, 22.5)
+		c.U3(4, 1, 40, 50, 70)
+		if err := c.Gate("iswap", 5, 0); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.DefineSubCircuit("Bell", sub, 0); err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+func TestQASMRoundTrip(t *testing.T) {
+	registry := qasmTestGates(t)
+	circuit := goqkit.MakeQBitsCircuit(6)
+	q := &circuit
+	q.SetGateRegistry(registry)
+	a := q.AssignQBits(3, "a")
+	b := q.AssignQBits(3, "b")
+
+	q.Had(a.ToGlobalQBits(7)|b.ToGlobalQBits(1), 0)
+	q.U3(b.ToGlobalQBits(2), 0, 30, 45, 60)
+	q.RotX(b.ToGlobalQBits(4), a.ToGlobalQBits(1), 75)
+	q.Phase(b.ToGlobalQBits(4), a.ToGlobalQBits(7), 15)
+	q.Not(b.ToGlobalQBits(2), a.ToGlobalQBits(7))
+	q.Swap(b.ToGlobalQBits(1), b.ToGlobalQBits(4), a.ToGlobalQBits(1))
+	q.OpSpace()
+	gates := []struct {
+		name             string
+		targets, control int
+	}{
+		{"Bell", a.ToGlobalQBits(7), 0},
+		{"Bell" + goqkit.GateInverseSuffix, b.ToGlobalQBits(7), a.ToGlobalQBits(1)},
+		{"iswap", a.ToGlobalQBits(6), b.ToGlobalQBits(3)},
+		{"iswap" + goqkit.GateInverseSuffix, b.ToGlobalQBits(6), 0},
+	}
+	for _, g := range gates {
+		if err := q.Gate(g.name, g.targets, g.control); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src, err := q.QASM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"qreg a[3];", "qreg b[3];", "u3(pi/6,pi/4,pi/3) b[1];", "crx(5*pi/12) a[0],b[2];",
+		"gate Bell q0,q1,q2 {", "gate Bell_dg_c1 q0,q1,q2,q3 {", "Bell_dg_c1 a[0],b[0],b[1],b[2];", "iswap_c2 b[0],b[1],a[1],a[2];"} {
+		if !strings.Contains(src, line+"\n") {
+			t.Errorf("QASM has no line %q:\n%s", line, src)
+		}
+	}
+
+	imported := goqkit.NewGateRegistry()
+	df, err := goqkit.ParseQASM([]byte(src), imported)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	var names []string
+	for _, op := range df.Operations {
+		if op.OpName == goqkit.OperationTypeGate {
+			names = append(names, op.GateName)
+			if len(op.ControlQBits) > 0 {
+				names[len(names)-1] += "/c"
+			}
+		}
+	}
+	if got, want := strings.Join(names, " "), "Bell Bell†/c iswap/c iswap†"; got != want {
+		t.Errorf("gate operations %q, expected %q", got, want)
+	}
+
+	replayed, err := goqkit.CircuitFromDump(df)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed.SetGateRegistry(imported)
+	if err := replayed.Replay(df.Operations); err != nil {
+		t.Fatal(err)
+	}
+	qtest.AssertStateClose(t, replayed, circuit.RawQBits, 1e-9)
+
+	// the gates are already defined in the original registry
+	if _, err := goqkit.ParseQASM([]byte(src), registry); err != nil {
+		t.Errorf("parse into the registry of the export: %v", err)
+	}
+}
+
+func TestQASMClassical(t *testing.T) {
+	circuit := goqkit.MakeQBitsCircuit(2)
+	q := &circuit
+	r := q.AssignQBits(2, "q")
+	c := q.AssignClassicalBits(1, "c")
+	q.Had(r.ToGlobalQBits(1), 0)
+	q.Not(r.ToGlobalQBits(2), r.ToGlobalQBits(1))
+	if _, err := r.Measure(1, c, 1); err != nil {
+		t.Fatal(err)
+	}
+	q.CIf(c, 1, func() {
+		q.X(r.ToGlobalQBits(2), 0)
+	})
+	q.ReadQBits(r.ToGlobalQBits(2))
+
+	src, err := q.QASM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `OPENQASM 2.0;
+include "qelib1.inc";
+qreg q[2];
+creg c[1];
+creg meas[2];
+h q[0];
+cx q[0],q[1];
+measure q[0] -> c[0];
+if(c==1) x q[1];
+measure q[1] -> meas[1];
+`
+	if src != want {
+		t.Errorf("got:\n%s\nexpected:\n%s", src, want)
+	}
+}
+
+func TestParseQASM(t *testing.T) {
+	src := `OPENQASM 2.0;
+include "qelib1.inc";
+// a comment
+qreg q[2];
+creg c[2];
+h q;
+cu1(pi/4) q[0],q[1];
+u2(0, -pi) q[1];
+sdg q[0];
+barrier q;
+measure q -> c;
+if(c==3) rz(1.5e-1) q[1];
+`
+	df, err := goqkit.ParseQASM([]byte(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		opName   string
+		target   uint
+		controls int
+		options  []float64
+	}{
+		{goqkit.OperationTypeHad, 1, 0, nil},
+		{goqkit.OperationTypeHad, 2, 0, nil},
+		{goqkit.OperationTypePhase, 2, 1, []float64{45}},
+		{goqkit.OperationTypeU3, 2, 0, []float64{90, 0, -180}},
+		{goqkit.OperationTypePhase, 1, 0, []float64{-90}},
+		{goqkit.OperationTypeSpace, 0, 0, nil},
+		{goqkit.OperationTypeRead, 1, 0, nil},
+		{goqkit.OperationTypeRead, 2, 0, nil},
+		{goqkit.OperationTypeRotate, 2, 0, []float64{0, 0, 0.15 * 180 / 3.141592653589793}},
+	}
+	if len(df.Operations) != len(want) {
+		t.Fatalf("%d operations, expected %d: %+v", len(df.Operations), len(want), df.Operations)
+	}
+	for i, w := range want {
+		op := df.Operations[i]
+		if op.OpName != w.opName || op.TargetQBit != w.target || len(op.ControlQBits) != w.controls || len(op.Options) != len(w.options) {
+			t.Errorf("operation %d: %+v, expected %+v", i, op, w)
+			continue
+		}
+		for k := range w.options {
+			if d := op.Options[k] - w.options[k]; d > 1e-9 || d < -1e-9 {
+				t.Errorf("operation %d: options %v, expected %v", i, op.Options, w.options)
+			}
+		}
+	}
+	if op := df.Operations[7]; op.ClassicalBit != 2 {
+		t.Errorf("measure into classical bit %d, expected 2", op.ClassicalBit)
+	}
+	if op := df.Operations[8]; op.Condition == nil || op.Condition.Register != "c" || op.Condition.Value != 3 || op.Axis != "Z" {
+		t.Errorf("conditional rz: %+v", op)
+	}
+	if len(df.Registers) != 1 || len(df.ClassicalRegisters) != 1 || len(df.QBits) != 4 {
+		t.Errorf("registers %+v, classical registers %+v, %d amplitudes", df.Registers, df.ClassicalRegisters, len(df.QBits))
+	}
+}
+
+func TestQASMErrors(t *testing.T) {
+	programs := map[string]string{
+		"parameters": "qreg q[1];\ngate g(theta) a { rx(theta) a; }\n",
+		"opaque":     "qreg q[1];\nopaque g a;\n",
+		"undefined":  "qreg q[1];\nfoo q[0];\n",
+		"include":    "include \"other.inc\";\n",
+		"register":   "qreg q[1];\nh r[0];\n",
+		"index":      "qreg q[1];\nh q[1];\n",
+		"twice":      "qreg q[2];\ncx q[0],q[0];\n",
+		"sizes":      "qreg q[2];\nqreg r[3];\ncx q,r;\n",
+	}
+	for name, src := range programs {
+		if _, err := goqkit.ParseQASM([]byte(src), nil); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	registry := goqkit.NewGateRegistry()
+	x := mat.NewMatrix(2, 2)
+	x.Set(0, 1, 1)
+	x.Set(1, 0, 1)
+	if err := registry.DefineMatrix("h", x); err != nil {
+		t.Fatal(err)
+	}
+	circuit := goqkit.MakeQBitsCircuit(1)
+	circuit.SetGateRegistry(registry)
+	circuit.AssignQBits(1, "q")
+	if err := circuit.Gate("h", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := circuit.QASM(); err == nil {
+		t.Error("gate named like a qelib1.inc gate is exported")
+	}
+}
@@ -0,0 +1,348 @@
+package goqkit
+
+import (
+	"fmt"
+)
+
+/*
+Handle of one qbit of a circuit, addressed by its index instead of a bitmask.
+
+A handle is made by Q of a circuit or of a register, and the handles of different registers
+can be mixed freely, e.g. a control of one register and a target of another one:
+
+	circuit.CX(a.Q(0), b.Q(3))
+
+Single qbit gates, reads and measurements are methods of the handle, with optional control qbits:
+
+	b.Q(1).RotY(45, a.Q(0), a.Q(2))
+
+Mask converts handles to the global qbits value of the bitmask APIs.
+*/
+type QBit struct {
+	index   int
+	circuit *QBitsCircuit
+}
+
+/*
+Return the handle of the qbit with the global index i (the qbit with the value 1<<i).
+
+An index out of range of the circuit returns an invalid handle and keeps the error for Err.
+*/
+func (q *QBitsCircuit) Q(i int) QBit {
+	if i < 0 || i >= int(q.QBitNumber) {
+		if q.err == nil {
+			q.err = fmt.Errorf("qbit index %d is out of range of %d qbits", i, q.QBitNumber)
+		}
+		return QBit{index: -1, circuit: q}
+	}
+	return QBit{index: i, circuit: q}
+}
+
+/*
+Return the handle of the i-th local qbit of this register.
+
+An index out of range of the register returns an invalid handle and keeps the error for Err of the circuit.
+*/
+func (reg *Register) Q(i int) QBit {
+	qbits := reg.qbitList
+	if i < 0 || i >= len(qbits) {
+		if reg.circuit.err == nil {
+			reg.circuit.err = fmt.Errorf("qbit index %d is out of range of register %q with %d qbits", i, reg.Name, len(qbits))
+		}
+		return QBit{index: -1, circuit: reg.circuit}
+	}
+	return QBit{index: qbitIndex(qbits[i]), circuit: reg.circuit}
+}
+
+/*
+Return the handles of all qbits of this register in local order.
+*/
+func (reg *Register) Qs() []QBit {
+	var handles []QBit
+	for _, qb := range reg.qbitList {
+		handles = append(handles, QBit{index: qbitIndex(qb), circuit: reg.circuit})
+	}
+	return handles
+}
+
+/*
+Return the global index of the qbit, or -1 for an invalid handle.
+*/
+func (b QBit) Index() int {
+	return b.index
+}
+
+/*
+Return the global qbits value (1<<index) of the qbit, or 0 for an invalid handle.
+*/
+func (b QBit) Value() int {
+	if b.index < 0 {
+		return 0
+	}
+	return 1 << uint(b.index)
+}
+
+/*
+Return the register which has the qbit, or nil.
+*/
+func (b QBit) Register() *Register {
+	if b.index < 0 || b.circuit == nil {
+		return nil
+	}
+	return b.circuit.GetRegister(b.Value())
+}
+
+/*
+Return the global qbits value of the handles, e.g. as the control value of the bitmask APIs:
+
+	circuit.RotY(b.Q(0).Value(), Mask(a.Q(0), a.Q(2)), 45)
+*/
+func Mask(qbits ...QBit) int {
+	val := 0
+	for _, b := range qbits {
+		val |= b.Value()
+	}
+	return val
+}
+
+/*
+Return the global qbits value of the handles, or false and keep the error for Err
+if a handle is invalid, of another circuit, or used twice.
+*/
+func (q *QBitsCircuit) maskOf(qbits ...QBit) (int, bool) {
+	val := 0
+	var err error
+	for _, b := range qbits {
+		switch {
+		case b.circuit != q:
+			err = fmt.Errorf("qbit %d is not a qbit of this circuit", b.index)
+		case b.index < 0:
+			err = fmt.Errorf("invalid qbit handle")
+		case val&b.Value() != 0:
+			err = fmt.Errorf("qbit %d is used twice", b.index)
+		}
+		if err != nil {
+			if q.err == nil {
+				q.err = err
+			}
+			return 0, false
+		}
+		val |= b.Value()
+	}
+	return val, true
+}
+
+/*
+Hadamard gate on a qbit with optional control qbits.
+*/
+func (q *QBitsCircuit) H(target QBit, controls ...QBit) {
+	if val, ok := q.maskOf(append([]QBit{target}, controls...)...); ok {
+		q.Had(target.Value(), val&^target.Value())
+	}
+}
+
+/*
+Controlled Not gate.
+*/
+func (q *QBitsCircuit) CX(control, target QBit) {
+	q.MCX([]QBit{control}, target)
+}
+
+/*
+Controlled Y gate.
+*/
+func (q *QBitsCircuit) CY(control, target QBit) {
+	if _, ok := q.maskOf(control, target); ok {
+		q.Y(target.Value(), control.Value())
+	}
+}
+
+/*
+Controlled Z gate.
+*/
+func (q *QBitsCircuit) CZ(control, target QBit) {
+	if _, ok := q.maskOf(control, target); ok {
+		q.Z(target.Value(), control.Value())
+	}
+}
+
+/*
+Toffoli (controlled controlled Not) gate.
+*/
+func (q *QBitsCircuit) CCX(control1, control2, target QBit) {
+	q.MCX([]QBit{control1, control2}, target)
+}
+
+/*
+Not gate with any number of control qbits.
+*/
+func (q *QBitsCircuit) MCX(controls []QBit, target QBit) {
+	if val, ok := q.maskOf(append([]QBit{target}, controls...)...); ok {
+		q.Not(target.Value(), val&^target.Value())
+	}
+}
+
+/*
+Controlled phase gate.
+
+deg: degree of the phase
+*/
+func (q *QBitsCircuit) CP(control, target QBit, deg float64) {
+	if _, ok := q.maskOf(control, target); ok {
+		q.Phase(target.Value(), control.Value(), deg)
+	}
+}
+
+/*
+Swap two qbits with optional control qbits (Fredkin gate with one control).
+*/
+func (q *QBitsCircuit) CSwap(a, b QBit, controls ...QBit) {
+	if val, ok := q.maskOf(append([]QBit{a, b}, controls...)...); ok {
+		q.Swap(a.Value(), b.Value(), val&^(a.Value()|b.Value()))
+	}
+}
+
+/*
+Return the global control qbits value of the handles for a gate on this qbit,
+or false and keep the error for Err if a handle is invalid.
+*/
+func (b QBit) controlValue(controls []QBit) (int, bool) {
+	if b.circuit == nil {
+		return 0, false
+	}
+	val, ok := b.circuit.maskOf(append([]QBit{b}, controls...)...)
+	return val &^ b.Value(), ok
+}
+
+/*
+X gate on this qbit with optional control qbits.
+*/
+func (b QBit) X(controls ...QBit) {
+	if c, ok := b.controlValue(controls); ok {
+		b.circuit.X(b.Value(), c)
+	}
+}
+
+/*
+Y gate on this qbit with optional control qbits.
+*/
+func (b QBit) Y(controls ...QBit) {
+	if c, ok := b.controlValue(controls); ok {
+		b.circuit.Y(b.Value(), c)
+	}
+}
+
+/*
+Z gate on this qbit with optional control qbits.
+*/
+func (b QBit) Z(controls ...QBit) {
+	if c, ok := b.controlValue(controls); ok {
+		b.circuit.Z(b.Value(), c)
+	}
+}
+
+/*
+Square root of X gate on this qbit with optional control qbits.
+*/
+func (b QBit) SX(controls ...QBit) {
+	if c, ok := b.controlValue(controls); ok {
+		b.circuit.SX(b.Value(), c)
+	}
+}
+
+/*
+Rotation around the X axis on this qbit with optional control qbits.
+
+deg: degree of the rotation
+*/
+func (b QBit) RotX(deg float64, controls ...QBit) {
+	if c, ok := b.controlValue(controls); ok {
+		b.circuit.RotX(b.Value(), c, deg)
+	}
+}
+
+/*
+Rotation around the Y axis on this qbit with optional control qbits.
+
+deg: degree of the rotation
+*/
+func (b QBit) RotY(deg float64, controls ...QBit) {
+	if c, ok := b.controlValue(controls); ok {
+		b.circuit.RotY(b.Value(), c, deg)
+	}
+}
+
+/*
+Rotation around the Z axis on this qbit with optional control qbits.
+
+deg: degree of the rotation
+*/
+func (b QBit) RotZ(deg float64, controls ...QBit) {
+	if c, ok := b.controlValue(controls); ok {
+		b.circuit.RotZ(b.Value(), c, deg)
+	}
+}
+
+/*
+Phase gate on this qbit with optional control qbits.
+
+deg: degree of the phase
+*/
+func (b QBit) Phase(deg float64, controls ...QBit) {
+	if c, ok := b.controlValue(controls); ok {
+		b.circuit.Phase(b.Value(), c, deg)
+	}
+}
+
+/*
+U3 gate on this qbit with optional control qbits.
+
+theta, phi, lambda: degree
+*/
+func (b QBit) U3(theta, phi, lambda float64, controls ...QBit) {
+	if c, ok := b.controlValue(controls); ok {
+		b.circuit.U3(b.Value(), c, theta, phi, lambda)
+	}
+}
+
+/*
+Read this qbit and return 0 or 1.
+*/
+func (b QBit) Read() int {
+	if _, ok := b.controlValue(nil); !ok {
+		return 0
+	}
+	if b.circuit.ReadQBits(b.Value()) != 0 {
+		return 1
+	}
+	return 0
+}
+
+/*
+Read this qbit into the i-th bit of a classical register and return 0 or 1, see Circuit.Measure.
+*/
+func (b QBit) Measure(creg *ClassicalRegister, i int) (int, error) {
+	if _, ok := b.controlValue(nil); !ok {
+		if b.circuit == nil {
+			return 0, fmt.Errorf("invalid qbit handle")
+		}
+		return 0, b.circuit.Err()
+	}
+	if i < 0 || i >= creg.numberOfBits {
+		return 0, fmt.Errorf("classical bit %d is out of range of register %q with %d bits", i, creg.Name, creg.numberOfBits)
+	}
+	r, err := b.circuit.Measure(b.Value(), creg.ToGlobalBits(1<<uint(i)))
+	if r != 0 {
+		return 1, err
+	}
+	return 0, err
+}
+
+/*
+Reset this qbit to |0>.
+*/
+func (b QBit) Reset() {
+	if _, ok := b.controlValue(nil); ok {
+		b.circuit.Reset(b.Value())
+	}
+}
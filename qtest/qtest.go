@@ -0,0 +1,192 @@
+/*
+qtest provides helpers to unit test quantum circuits built with goqkit
+*/
+package qtest
+
+import (
+	"fmt"
+	"github.com/takezo5096/goqkit"
+	"github.com/takezo5096/goqkit/mat"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+)
+
+/*
+Make a state vector of n qbits from the amplitudes of the basis states.
+
+Basis states which are not in amplitudes are 0.
+
+Example: State(2, map[uint]complex128{0: 1 / math.Sqrt2, 3: 1 / math.Sqrt2})
+*/
+func State(n uint, amplitudes map[uint]complex128) mat.Vector {
+	v := mat.NewVector(1 << n)
+	for i, a := range amplitudes {
+		v.Set(i, a)
+	}
+	return v
+}
+
+/*
+Fail the test unless the qbits of circuit equal expected within tol, ignoring the global phase.
+*/
+func AssertStateClose(t testing.TB, circuit *goqkit.QBitsCircuit, expected mat.Vector, tol float64) bool {
+	t.Helper()
+	if circuit.RawQBits.N != expected.N {
+		t.Errorf("state size mismatch: got %d amplitudes, expected %d", circuit.RawQBits.N, expected.N)
+		return false
+	}
+	if !goqkit.EqualUpToGlobalPhase(expected, circuit.RawQBits, tol) {
+		t.Errorf("state mismatch (fidelity %.6f):\n got:      %s\n expected: %s",
+			goqkit.Fidelity(circuit.RawQBits, expected), circuit.DiracString(tol), diracString(expected, tol))
+		return false
+	}
+	return true
+}
+
+func diracString(v mat.Vector, tol float64) string {
+	n := 0
+	for 1<<uint(n) < v.N {
+		n++
+	}
+	c := goqkit.MakeQBitsCircuit(n)
+	c.RawQBits = v
+	return c.DiracString(tol)
+}
+
+/*
+Run f shots times and count how often each value is returned.
+*/
+func Counts(shots int, f func() int) map[int]int {
+	counts := make(map[int]int)
+	for i := 0; i < shots; i++ {
+		counts[f()]++
+	}
+	return counts
+}
+
+/*
+Pearson's chi-square goodness of fit test of counts against the expected probabilities.
+
+Return the statistic, the degrees of freedom and the p-value.
+A value which is counted but has no expected probability makes the p-value 0.
+*/
+func ChiSquare(counts map[int]int, expected map[int]float64) (float64, int, float64) {
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	for v, c := range counts {
+		if c > 0 && expected[v] <= 0 {
+			return math.Inf(1), 0, 0
+		}
+	}
+
+	stat := 0.0
+	categories := 0
+	for v, p := range expected {
+		if p <= 0 {
+			continue
+		}
+		e := p * float64(total)
+		d := float64(counts[v]) - e
+		stat += d * d / e
+		categories++
+	}
+	dof := categories - 1
+	if dof <= 0 {
+		return stat, 0, 1
+	}
+	return stat, dof, 1 - regularizedGammaP(float64(dof)/2, stat/2)
+}
+
+/*
+Fail the test if the chi-square test rejects that counts follow expected at significance level alpha.
+
+Example: AssertDistribution(t, counts, map[int]float64{0: 0.5, 3: 0.5}, 0.001)
+*/
+func AssertDistribution(t testing.TB, counts map[int]int, expected map[int]float64, alpha float64) bool {
+	t.Helper()
+	stat, dof, p := ChiSquare(counts, expected)
+	if p < alpha {
+		t.Errorf("distribution mismatch: chi-square %.3f with %d degrees of freedom, p-value %.3g < %.3g\n got:      %s\n expected: %s",
+			stat, dof, p, alpha, formatCounts(counts), formatExpected(expected))
+		return false
+	}
+	return true
+}
+
+func formatCounts(counts map[int]int) string {
+	keys := make([]int, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	s := make([]string, 0, len(keys))
+	for _, k := range keys {
+		s = append(s, fmt.Sprintf("%d:%d", k, counts[k]))
+	}
+	return strings.Join(s, " ")
+}
+
+func formatExpected(expected map[int]float64) string {
+	keys := make([]int, 0, len(expected))
+	for k := range expected {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	s := make([]string, 0, len(keys))
+	for _, k := range keys {
+		s = append(s, fmt.Sprintf("%d:%.3f", k, expected[k]))
+	}
+	return strings.Join(s, " ")
+}
+
+/*
+Regularized lower incomplete gamma function P(a, x).
+*/
+func regularizedGammaP(a, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	lgamma, _ := math.Lgamma(a)
+	if x < a+1 {
+		// series expansion
+		sum := 1 / a
+		term := sum
+		for n := 1; n < 1000; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*1e-15 {
+				break
+			}
+		}
+		return sum * math.Exp(-x+a*math.Log(x)-lgamma)
+	}
+	// continued fraction for Q(a, x) by the modified Lentz method
+	tiny := 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for n := 1; n < 1000; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < 1e-15 {
+			break
+		}
+	}
+	return 1 - math.Exp(-x+a*math.Log(x)-lgamma)*h
+}
@@ -0,0 +1,108 @@
+package qtest
+
+import (
+	"github.com/takezo5096/goqkit"
+	"math"
+	"testing"
+)
+
+/*
+testing.TB which records failures instead of failing the test.
+*/
+type fakeTB struct {
+	testing.TB
+	failed bool
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...interface{}) {
+	f.failed = true
+}
+
+func TestAssertStateClose(t *testing.T) {
+	circuit := goqkit.MakeQBitsCircuit(2)
+	circuit.AssignQBits(2, "q")
+	circuit.Had(1, 0)
+	circuit.Not(2, 1)
+	bell := State(2, map[uint]complex128{0: 1 / math.Sqrt2, 3: 1 / math.Sqrt2})
+	minusBell := State(2, map[uint]complex128{0: -1 / math.Sqrt2, 3: -1 / math.Sqrt2})
+
+	if !AssertStateClose(t, &circuit, bell, 1e-9) {
+		t.Error("Bell state does not match")
+	}
+	if !AssertStateClose(t, &circuit, minusBell, 1e-9) {
+		t.Error("Bell state does not match up to the global phase")
+	}
+
+	cases := map[string]struct {
+		n          uint
+		amplitudes map[uint]complex128
+	}{
+		"other state": {2, map[uint]complex128{0: 1 / math.Sqrt2, 3: -1 / math.Sqrt2}},
+		"other size":  {3, map[uint]complex128{0: 1 / math.Sqrt2, 3: 1 / math.Sqrt2}},
+	}
+	for name, c := range cases {
+		tb := &fakeTB{}
+		if AssertStateClose(tb, &circuit, State(c.n, c.amplitudes), 1e-9) || !tb.failed {
+			t.Errorf("%s: no failure", name)
+		}
+	}
+}
+
+func TestAssertDistribution(t *testing.T) {
+	expected := map[int]float64{0: 0.5, 3: 0.5}
+
+	if !AssertDistribution(t, map[int]int{0: 510, 3: 490}, expected, 0.001) {
+		t.Error("fair counts are rejected")
+	}
+
+	cases := map[string]map[int]int{
+		"biased":     {0: 900, 3: 100},
+		"unexpected": {0: 500, 3: 499, 1: 1},
+	}
+	for name, counts := range cases {
+		tb := &fakeTB{}
+		if AssertDistribution(tb, counts, expected, 0.001) || !tb.failed {
+			t.Errorf("%s: no failure", name)
+		}
+	}
+}
+
+func TestChiSquare(t *testing.T) {
+	cases := []struct {
+		counts   map[int]int
+		expected map[int]float64
+		stat     float64
+		dof      int
+		p        float64
+	}{
+		{map[int]int{0: 50, 1: 50}, map[int]float64{0: 0.5, 1: 0.5}, 0, 1, 1},
+		{map[int]int{0: 60, 1: 40}, map[int]float64{0: 0.5, 1: 0.5}, 4, 1, 0.0455003},
+		{map[int]int{0: 30, 1: 30, 2: 40}, map[int]float64{0: 0.25, 1: 0.25, 2: 0.5}, 4, 2, math.Exp(-2)},
+		// 3 is not expected
+		{map[int]int{0: 50, 3: 1}, map[int]float64{0: 0.5, 1: 0.5}, math.Inf(1), 0, 0},
+		{map[int]int{0: 10}, map[int]float64{0: 1}, 0, 0, 1},
+	}
+	for _, c := range cases {
+		stat, dof, p := ChiSquare(c.counts, c.expected)
+		if math.Abs(stat-c.stat) > 1e-9 && !(math.IsInf(stat, 1) && math.IsInf(c.stat, 1)) || dof != c.dof || math.Abs(p-c.p) > 1e-6 {
+			t.Errorf("ChiSquare(%v, %v) = %g, %d, %g, expected %g, %d, %g", c.counts, c.expected, stat, dof, p, c.stat, c.dof, c.p)
+		}
+	}
+}
+
+func TestRegularizedGammaP(t *testing.T) {
+	// P(1, x) = 1 - e^-x, x < a+1 takes the series and larger x the continued fraction
+	for _, x := range []float64{0, 0.5, 1.9, 2.1, 5, 30} {
+		if got, want := regularizedGammaP(1, x), 1-math.Exp(-x); math.Abs(got-want) > 1e-12 {
+			t.Errorf("P(1, %g) = %g, expected %g", x, got, want)
+		}
+	}
+	// P(1/2, x) = erf(sqrt(x))
+	for _, x := range []float64{0.1, 1, 4} {
+		if got, want := regularizedGammaP(0.5, x), math.Erf(math.Sqrt(x)); math.Abs(got-want) > 1e-12 {
+			t.Errorf("P(1/2, %g) = %g, expected %g", x, got, want)
+		}
+	}
+}
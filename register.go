@@ -1,5 +1,9 @@
 package goqkit
 
+import (
+	"fmt"
+)
+
 /*
 QBits register which has same of qbits and can apply the many quantum gates
 */
@@ -8,8 +12,10 @@ type Register struct {
 	numberOfQBits int
 	//QBits value. if this register has 0x01,0x02 qbits, then QBits will be 0x03
 	qBits uint
-	//How many shift from local to global value in circuit.
+	//How many shift from local to global value in circuit. the index of the lowest qbit for non contiguous registers.
 	shift int
+	//Global value of each local qbit, the i-th local qbit is qbitList[i]
+	qbitList []uint
 	//Pointer of the circuit.
 	circuit *QBitsCircuit
 
@@ -47,9 +53,39 @@ The register use local qbits value on basis,
 so depending on the situation, transfer from local value in this register to global one in global circuit.
 
 Example: if shift is 4 and this register has 0x01 local value, this function will return 0x08 by shifting 4.
+
+For slices, aliases and non contiguous registers the i-th local qbit is mapped to the i-th qbit of the register.
+
+A value with bits outside of this register returns 0 and keeps the error for Err of the circuit,
+so that later gates are skipped until ClearErr instead of running without these qbits.
 */
 func (reg *Register) ToGlobalQBits(val int) int {
-	return val << reg.shift
+	if val < 0 || val>>uint(len(reg.qbitList)) != 0 {
+		if reg.circuit.err == nil {
+			reg.circuit.err = fmt.Errorf("local qbits %b are out of range of register %q with %d qbits", uint(val), reg.Name, reg.numberOfQBits)
+		}
+		return 0
+	}
+	global := 0
+	for i, qb := range reg.qbitList {
+		if val&(1<<uint(i)) != 0 {
+			global |= int(qb)
+		}
+	}
+	return global
+}
+
+/*
+Return the local qbits value of this register from a global qbits value. Qbits of other registers are ignored.
+*/
+func (reg *Register) ToLocalQBits(val int) int {
+	local := 0
+	for i, qb := range reg.qbitList {
+		if val&int(qb) != 0 {
+			local |= 1 << uint(i)
+		}
+	}
+	return local
 }
 
 /*
@@ -58,7 +94,7 @@ Read all qbits value in this register and return local integer value.
 func (reg *Register) ReadAll() int {
 	r := reg.circuit.ReadQBits(int(reg.GetQBits()))
 	//back to local
-	return r >> reg.shift
+	return reg.ToLocalQBits(r)
 }
 
 /*
@@ -70,7 +106,7 @@ func (reg *Register) Read(val int) int {
 	qbits := reg.ToGlobalQBits(val)
 	r := reg.circuit.ReadQBits(qbits)
 	//back to local
-	return r >> reg.shift
+	return reg.ToLocalQBits(r)
 }
 
 /*
@@ -83,6 +119,21 @@ func (reg *Register) Write(val int) {
 	reg.circuit.Write(qbits)
 }
 
+/*
+Reset the qbits specified as val to |0>.
+*/
+func (reg *Register) Reset(val int) {
+	qbits := reg.ToGlobalQBits(val)
+	reg.circuit.Reset(qbits)
+}
+
+/*
+Reset all qbits in this register to |0>.
+*/
+func (reg *Register) ResetAll() {
+	reg.circuit.Reset(int(reg.GetQBits()))
+}
+
 /*
 Apply Not Gate to all qbits in this register
 */
@@ -246,6 +297,48 @@ func (reg *Register) Phase(val int, control int, deg float64) {
 	reg.circuit.Phase(qbits, control, deg)
 }
 
+/*
+Apply SX Gate, the square root of X, to the value with control qbits.
+
+val: local qbits value
+
+control: global control qbits value
+*/
+func (reg *Register) SX(val int, control int) {
+	qbits := reg.ToGlobalQBits(val)
+	reg.circuit.SX(qbits, control)
+}
+
+/*
+Apply a user defined gate of the gate registry of the circuit to the value with control qbits.
+
+name: name of the gate
+
+val: local qbits value, the lowest local qbit is qbit 0 of the gate
+
+control: global control qbits value
+*/
+func (reg *Register) Gate(name string, val int, control int) error {
+	if val < 0 || val>>uint(reg.numberOfQBits) != 0 {
+		return fmt.Errorf("gate %q: local qbits %b are out of range of register %q with %d qbits", name, uint(val), reg.Name, reg.numberOfQBits)
+	}
+	return reg.circuit.gate(name, reg.localQBitList(val), control)
+}
+
+/*
+Apply U3 Gate to the value with control qbits.
+
+val: local qbits value
+
+control: global control qbits value
+
+theta, phi, lambda: degree
+*/
+func (reg *Register) U3(val int, control int, theta, phi, lambda float64) {
+	qbits := reg.ToGlobalQBits(val)
+	reg.circuit.U3(qbits, control, theta, phi, lambda)
+}
+
 /*
 Apply Swap Gate to all qbits in this register
 
@@ -258,8 +351,8 @@ control: global control qbits value
 */
 func (reg *Register) Swap(targetVal int, swapVal int, control int) {
 	tqbits := reg.ToGlobalQBits(targetVal)
-	reg.circuit.Swap(tqbits, swapVal, control)
-
+	sqbits := reg.ToGlobalQBits(swapVal)
+	reg.circuit.Swap(tqbits, sqbits, control)
 }
 
 /*
@@ -270,7 +363,7 @@ control: global control qbits value
 numShift: number of shift
 */
 func (reg *Register) ShiftLeft(control int, numShift int) {
-	reg.circuit.ShiftLeft(int(reg.GetQBits()), control, numShift)
+	reg.circuit.shiftLeft(reg.qbitList, control, numShift)
 }
 
 /*
@@ -279,7 +372,7 @@ Quantum version of Discrete Fourier transform(DFT)
 Apply all qbits in this register.
 */
 func (reg *Register) QFT() {
-	reg.circuit.QFT(int(reg.GetQBits()))
+	reg.circuit.qft(reg.qbitList)
 }
 
 /*
@@ -288,7 +381,7 @@ Quantum version of Inversed Discrete Fourier transform(InvDFT)
 Apply all qbits in this register.
 */
 func (reg *Register) InversedQFT() {
-	reg.circuit.InversedQFT(int(reg.GetQBits()))
+	reg.circuit.inversedQFT(reg.qbitList)
 }
 
 /*
@@ -301,7 +394,11 @@ control: global control qbits value
 Example: Subtract(3, 0)
 */
 func (reg *Register) Subtract(val int, control int) {
-	reg.circuit.Subtract(int(reg.GetQBits()), val, control)
+	if val < 0 {
+		reg.addImpl(-val, control, false)
+		return
+	}
+	reg.addImpl(val, control, true)
 }
 
 /*
@@ -310,7 +407,7 @@ Subtract a register
 registerB: the register to subtract to this register
 */
 func (reg *Register) SubtractRegister(registerB Register) {
-	reg.Subtract(int(registerB.GetQBits()>>registerB.shift), int(registerB.GetQBits()))
+	reg.Subtract((1<<uint(registerB.numberOfQBits))-1, registerB.ToGlobalQBits((1<<uint(registerB.numberOfQBits))-1))
 }
 
 /*
@@ -323,7 +420,46 @@ control: global control qbits value
 Example: Add(3, 0)
 */
 func (reg *Register) Add(val int, control int) {
-	reg.circuit.Add(int(reg.GetQBits()), val, control)
+	if val < 0 {
+		reg.addImpl(-val, control, true)
+		return
+	}
+	reg.addImpl(val, control, false)
+}
+
+/*
+Add or subtract 2^i for every bit i of val, controlled by the qbit of control at the same position
+among the set bits as in Circuit.Add, or without control if control is 0.
+*/
+func (reg *Register) addImpl(val int, control int, subtract bool) {
+	var positions []int
+	for i := range reg.qbitList {
+		if val&(1<<uint(i)) != 0 {
+			positions = append(positions, i)
+		}
+	}
+	controls := reg.circuit.GetQBits(control)
+	if control != 0 && len(controls) < len(positions) {
+		if reg.circuit.err == nil {
+			reg.circuit.err = fmt.Errorf("register %q: %d control qbits for %d bits of %d", reg.Name, len(controls), len(positions), val)
+		}
+		return
+	}
+	for k := range positions {
+		if subtract {
+			// subtract from the highest bit like Circuit.Subtract
+			k = len(positions) - 1 - k
+		}
+		c := 0
+		if control != 0 {
+			c = int(controls[k])
+		}
+		if subtract {
+			reg.circuit.decrementQBits(reg.qbitList[positions[k]:], c)
+		} else {
+			reg.circuit.incrementQBits(reg.qbitList[positions[k]:], c)
+		}
+	}
 }
 
 /*
@@ -332,5 +468,5 @@ Add a register
 registerB: the register to add to this register
 */
 func (reg *Register) AddRegister(registerB *Register) {
-	reg.Add(int(registerB.GetQBits()>>registerB.shift), int(registerB.GetQBits()))
+	reg.Add((1<<uint(registerB.numberOfQBits))-1, registerB.ToGlobalQBits((1<<uint(registerB.numberOfQBits))-1))
 }
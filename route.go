@@ -0,0 +1,584 @@
+package goqkit
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+Connectivity of the physical qbits of a device.
+
+Two qbit gates can only act on qbits which are joined by an edge. Edges are undirected.
+*/
+type CouplingMap struct {
+	n     int
+	edges [][2]int
+	adj   [][]int
+	dist  [][]int
+}
+
+/*
+Make a coupling map of n physical qbits from the edges between them.
+
+All qbits have to be connected.
+*/
+func NewCouplingMap(n int, edges [][2]int) (*CouplingMap, error) {
+	c := &CouplingMap{n: n, adj: make([][]int, n)}
+	seen := make(map[[2]int]bool)
+	for _, e := range edges {
+		a, b := e[0], e[1]
+		if a < 0 || b < 0 || a >= n || b >= n || a == b {
+			return nil, fmt.Errorf("invalid edge %d-%d for %d qbits", a, b, n)
+		}
+		if a > b {
+			a, b = b, a
+		}
+		if seen[[2]int{a, b}] {
+			continue
+		}
+		seen[[2]int{a, b}] = true
+		c.edges = append(c.edges, [2]int{a, b})
+		c.adj[a] = append(c.adj[a], b)
+		c.adj[b] = append(c.adj[b], a)
+	}
+	for i := range c.adj {
+		sort.Ints(c.adj[i])
+	}
+
+	// distances by breadth first search from every qbit
+	c.dist = make([][]int, n)
+	for s := 0; s < n; s++ {
+		d := make([]int, n)
+		for i := range d {
+			d[i] = -1
+		}
+		d[s] = 0
+		queue := []int{s}
+		for len(queue) > 0 {
+			v := queue[0]
+			queue = queue[1:]
+			for _, w := range c.adj[v] {
+				if d[w] < 0 {
+					d[w] = d[v] + 1
+					queue = append(queue, w)
+				}
+			}
+		}
+		for i, x := range d {
+			if x < 0 {
+				return nil, fmt.Errorf("qbit %d is not connected to qbit %d", i, s)
+			}
+		}
+		c.dist[s] = d
+	}
+	return c, nil
+}
+
+/*
+Make a coupling map of n qbits in a line.
+*/
+func LineCouplingMap(n int) *CouplingMap {
+	var edges [][2]int
+	for i := 0; i+1 < n; i++ {
+		edges = append(edges, [2]int{i, i + 1})
+	}
+	c, _ := NewCouplingMap(n, edges)
+	return c
+}
+
+/*
+Make a coupling map of n qbits in a ring.
+*/
+func RingCouplingMap(n int) *CouplingMap {
+	var edges [][2]int
+	for i := 0; i < n && n > 1; i++ {
+		edges = append(edges, [2]int{i, (i + 1) % n})
+	}
+	c, _ := NewCouplingMap(n, edges)
+	return c
+}
+
+/*
+Make a coupling map of rows x cols qbits in a grid. Qbit r*cols+c is at row r and column c.
+*/
+func GridCouplingMap(rows, cols int) *CouplingMap {
+	var edges [][2]int
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			if c+1 < cols {
+				edges = append(edges, [2]int{r*cols + c, r*cols + c + 1})
+			}
+			if r+1 < rows {
+				edges = append(edges, [2]int{r*cols + c, (r+1)*cols + c})
+			}
+		}
+	}
+	m, _ := NewCouplingMap(rows*cols, edges)
+	return m
+}
+
+/*
+Return the number of physical qbits.
+*/
+func (c *CouplingMap) NumberOfQBits() int {
+	return c.n
+}
+
+/*
+Return all edges with the smaller qbit first.
+*/
+func (c *CouplingMap) Edges() [][2]int {
+	return c.edges
+}
+
+/*
+Return true if two qbit gates can act on the physical qbits a and b.
+*/
+func (c *CouplingMap) Connected(a, b int) bool {
+	return c.dist[a][b] == 1
+}
+
+/*
+Return the number of edges on the shortest path between the physical qbits a and b.
+*/
+func (c *CouplingMap) Distance(a, b int) int {
+	return c.dist[a][b]
+}
+
+/*
+Options of Route.
+*/
+type RoutingOptions struct {
+	//Physical qbit index of each logical qbit index before the circuit, chosen by Route when nil
+	InitialLayout []int
+	//Number of forward and backward passes to choose the initial layout, 3 when 0
+	LayoutIterations int
+}
+
+/*
+Result of Route.
+*/
+type RoutingResult struct {
+	//Operations on physical qbits, with inserted Swap operations
+	Operations []Operation
+	//Physical qbit index of each logical qbit index before the circuit
+	InitialLayout []int
+	//Physical qbit index of each logical qbit index after the circuit
+	FinalLayout []int
+	//Number of inserted Swap operations
+	SwapCount int
+}
+
+/*
+Number of two qbit gates ahead of the front layer which the swap heuristic looks at.
+*/
+const routingLookahead = 20
+
+/*
+Weight of the lookahead gates in the swap heuristic.
+*/
+const routingLookaheadWeight = 0.5
+
+/*
+Increase of the decay of a qbit which takes part in a swap,
+which makes the heuristic prefer swaps on other qbits and so parallel swaps.
+*/
+const routingDecay = 0.001
+
+/*
+Route the operations recorded in this circuit onto the coupling map.
+
+See Route.
+*/
+func (q *QBitsCircuit) Route(coupling *CouplingMap, opts RoutingOptions) (RoutingResult, error) {
+	return Route(q.QBitNumber, q.GetOperations(), coupling, opts)
+}
+
+/*
+Map operations on n logical qbits onto the physical qbits of a device,
+inserting Swap operations so that every two qbit gate acts on connected qbits.
+
+Swaps are chosen by the SABRE heuristic, which minimizes the distance between the qbits
+of the gates which are ready to run and, with less weight, of the gates which follow them.
+Unless opts gives one, the initial layout is improved by routing the circuit forward and backward
+starting from the trivial layout, and the layout with the fewest swaps is taken.
+
+Gates on more than two qbits, counting all target qbits of user defined gates,
+have to be decomposed first, e.g. by Transpile.
+
+After the routed operations the state of logical qbit i is on physical qbit FinalLayout[i];
+use LogicalValue or RegisterValue to map read values back.
+*/
+func Route(n uint, ops []Operation, coupling *CouplingMap, opts RoutingOptions) (RoutingResult, error) {
+	if int(n) > coupling.n {
+		return RoutingResult{}, fmt.Errorf("circuit has %d qbits but device only %d", n, coupling.n)
+	}
+	for k, op := range ops {
+		if len(op.QBits()) > 2 {
+			return RoutingResult{}, fmt.Errorf("operation %d acts on %d qbits, decompose it first", k, len(op.QBits()))
+		}
+	}
+
+	var layout []int
+	if opts.InitialLayout != nil {
+		if err := checkLayout(opts.InitialLayout, int(n), coupling.n); err != nil {
+			return RoutingResult{}, err
+		}
+		layout = completeLayout(opts.InitialLayout, coupling.n)
+	} else {
+		iterations := opts.LayoutIterations
+		if iterations <= 0 {
+			iterations = 3
+		}
+		reversed := make([]Operation, len(ops))
+		for i, op := range ops {
+			reversed[len(ops)-1-i] = op
+		}
+		layout = completeLayout(nil, coupling.n)
+		best, bestSwaps := layout, routeSwaps(n, ops, coupling, layout)
+		for i := 0; i < iterations; i++ {
+			forward := newRouter(n, ops, coupling, layout)
+			forward.run()
+			backward := newRouter(n, reversed, coupling, forward.l2p)
+			backward.run()
+			layout = backward.l2p
+			if swaps := routeSwaps(n, ops, coupling, layout); swaps < bestSwaps {
+				best, bestSwaps = layout, swaps
+			}
+		}
+		layout = best
+	}
+
+	r := newRouter(n, ops, coupling, layout)
+	r.emit = true
+	r.run()
+	return RoutingResult{
+		Operations:    r.out,
+		InitialLayout: append([]int{}, layout[:n]...),
+		FinalLayout:   append([]int{}, r.l2p[:n]...),
+		SwapCount:     r.swaps,
+	}, nil
+}
+
+func routeSwaps(n uint, ops []Operation, coupling *CouplingMap, layout []int) int {
+	r := newRouter(n, ops, coupling, layout)
+	r.run()
+	return r.swaps
+}
+
+func checkLayout(layout []int, n, physical int) error {
+	if len(layout) != n {
+		return fmt.Errorf("layout has %d qbits instead of %d", len(layout), n)
+	}
+	used := make(map[int]bool)
+	for i, p := range layout {
+		if p < 0 || p >= physical || used[p] {
+			return fmt.Errorf("invalid physical qbit %d for logical qbit %d", p, i)
+		}
+		used[p] = true
+	}
+	return nil
+}
+
+/*
+Extend a layout of logical qbits to all physical qbits, placing idle logical qbits on the unused physical ones.
+*/
+func completeLayout(layout []int, physical int) []int {
+	full := append([]int{}, layout...)
+	used := make(map[int]bool)
+	for _, p := range layout {
+		used[p] = true
+	}
+	for p := 0; p < physical; p++ {
+		if !used[p] {
+			full = append(full, p)
+		}
+	}
+	return full
+}
+
+type router struct {
+	coupling *CouplingMap
+	dag      *DAG
+	ops      []Operation
+	//physical qbit index of each logical qbit index and the inverse
+	l2p []int
+	p2l []int
+
+	//number of unexecuted predecessors of each node
+	waiting []int
+	front   []int
+	decay   []float64
+
+	emit  bool
+	out   []Operation
+	swaps int
+}
+
+func newRouter(n uint, ops []Operation, coupling *CouplingMap, layout []int) *router {
+	r := &router{coupling: coupling, dag: NewDAG(n, ops), ops: ops,
+		l2p: append([]int{}, layout...), p2l: make([]int, coupling.n),
+		waiting: make([]int, len(ops)), decay: make([]float64, coupling.n)}
+	for l, p := range r.l2p {
+		r.p2l[p] = l
+	}
+	for i := range ops {
+		r.waiting[i] = len(r.dag.Predecessors(i))
+		if r.waiting[i] == 0 {
+			r.front = append(r.front, i)
+		}
+	}
+	for i := range r.decay {
+		r.decay[i] = 1
+	}
+	return r
+}
+
+func (r *router) run() {
+	stuck := 0
+	for len(r.front) > 0 {
+		if r.executeReady() {
+			stuck = 0
+			for i := range r.decay {
+				r.decay[i] = 1
+			}
+			continue
+		}
+
+		stuck++
+		if stuck > 10*r.coupling.n {
+			// the heuristic goes round in circles, bring the first gate together along a shortest path
+			a, b := r.physicalPair(r.front[0])
+			for !r.coupling.Connected(a, b) {
+				for _, nb := range r.coupling.adj[a] {
+					if r.coupling.dist[nb][b] < r.coupling.dist[a][b] {
+						r.swap(a, nb, r.front[0])
+						a = nb
+						break
+					}
+				}
+			}
+			continue
+		}
+
+		a, b := r.bestSwap()
+		r.swap(a, b, r.front[0])
+		r.decay[a] += routingDecay
+		r.decay[b] += routingDecay
+	}
+}
+
+/*
+Execute all gates of the front layer which act on connected qbits, and return true if any was executed.
+*/
+func (r *router) executeReady() bool {
+	executed := false
+	var next []int
+	for _, i := range r.front {
+		if len(r.ops[i].QBits()) == 2 {
+			a, b := r.physicalPair(i)
+			if !r.coupling.Connected(a, b) {
+				next = append(next, i)
+				continue
+			}
+		}
+		executed = true
+		if r.emit {
+			r.out = append(r.out, r.physicalOperation(r.ops[i]))
+		}
+		for _, s := range r.dag.Successors(i) {
+			r.waiting[s]--
+			if r.waiting[s] == 0 {
+				next = append(next, s)
+			}
+		}
+	}
+	sort.Ints(next)
+	r.front = next
+	return executed
+}
+
+/*
+Return the swap on an edge next to the front layer with the lowest heuristic cost.
+*/
+func (r *router) bestSwap() (int, int) {
+	extended := r.extendedSet()
+
+	candidates := make(map[[2]int]bool)
+	for _, i := range r.front {
+		a, b := r.physicalPair(i)
+		for _, p := range []int{a, b} {
+			for _, nb := range r.coupling.adj[p] {
+				if p < nb {
+					candidates[[2]int{p, nb}] = true
+				} else {
+					candidates[[2]int{nb, p}] = true
+				}
+			}
+		}
+	}
+	edges := make([][2]int, 0, len(candidates))
+	for e := range candidates {
+		edges = append(edges, e)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i][0] != edges[j][0] {
+			return edges[i][0] < edges[j][0]
+		}
+		return edges[i][1] < edges[j][1]
+	})
+
+	best := edges[0]
+	bestScore := 0.0
+	for k, e := range edges {
+		r.swapLayout(e[0], e[1])
+		score := r.distanceSum(r.front) / float64(len(r.front))
+		if len(extended) > 0 {
+			score += routingLookaheadWeight * r.distanceSum(extended) / float64(len(extended))
+		}
+		r.swapLayout(e[0], e[1])
+
+		d := r.decay[e[0]]
+		if r.decay[e[1]] > d {
+			d = r.decay[e[1]]
+		}
+		score *= d
+		if k == 0 || score < bestScore {
+			best, bestScore = e, score
+		}
+	}
+	return best[0], best[1]
+}
+
+/*
+Return up to routingLookahead two qbit gates which follow the front layer.
+*/
+func (r *router) extendedSet() []int {
+	var extended []int
+	waiting := make(map[int]int)
+	queue := append([]int{}, r.front...)
+	for len(queue) > 0 && len(extended) < routingLookahead {
+		i := queue[0]
+		queue = queue[1:]
+		for _, s := range r.dag.Successors(i) {
+			if _, ok := waiting[s]; !ok {
+				waiting[s] = r.waiting[s]
+			}
+			waiting[s]--
+			if waiting[s] == 0 {
+				if len(r.ops[s].QBits()) == 2 {
+					extended = append(extended, s)
+				}
+				queue = append(queue, s)
+			}
+		}
+	}
+	return extended
+}
+
+func (r *router) distanceSum(nodes []int) float64 {
+	sum := 0
+	for _, i := range nodes {
+		if len(r.ops[i].QBits()) == 2 {
+			a, b := r.physicalPair(i)
+			sum += r.coupling.dist[a][b]
+		}
+	}
+	return float64(sum)
+}
+
+/*
+Return the physical qbit indexes of a two qbit gate.
+
+The qbits are those of Operation.QBits, so a user defined gate on two target qbits is a two qbit gate too.
+*/
+func (r *router) physicalPair(i int) (int, int) {
+	qbits := r.ops[i].QBits()
+	return r.l2p[qbitIndex(qbits[0])], r.l2p[qbitIndex(qbits[1])]
+}
+
+func (r *router) swapLayout(a, b int) {
+	la, lb := r.p2l[a], r.p2l[b]
+	r.p2l[a], r.p2l[b] = lb, la
+	r.l2p[la], r.l2p[lb] = b, a
+}
+
+/*
+Insert a swap of the physical qbits a and b, taking the register of the operation i.
+*/
+func (r *router) swap(a, b int, i int) {
+	r.swapLayout(a, b)
+	r.swaps++
+	if r.emit {
+		src := r.ops[i]
+		r.out = append(r.out, Operation{OpName: OperationTypeSwap, RegisterName: src.RegisterName,
+			RegisterNameString: src.RegisterNameString, TargetQBit: 1 << uint(a), SwapQBit: 1 << uint(b)})
+	}
+}
+
+func (r *router) physicalOperation(op Operation) Operation {
+	phys := func(qb uint) uint {
+		return 1 << uint(r.l2p[qbitIndex(qb)])
+	}
+	if op.OpName == OperationTypeSpace {
+		return op
+	}
+	op.TargetQBit = phys(op.TargetQBit)
+	if op.SwapQBit != 0 {
+		op.SwapQBit = phys(op.SwapQBit)
+	}
+	if op.TargetQBits != nil {
+		targets := make([]uint, len(op.TargetQBits))
+		for k, t := range op.TargetQBits {
+			targets[k] = phys(t)
+		}
+		op.TargetQBits = targets
+	}
+	if op.ControlQBits != nil {
+		controls := make([]uint, len(op.ControlQBits))
+		for k, c := range op.ControlQBits {
+			controls[k] = phys(c)
+		}
+		op.ControlQBits = controls
+	}
+	return op
+}
+
+/*
+Map a value read from the physical qbits after the routed operations to the value of the logical qbits.
+*/
+func (res RoutingResult) LogicalValue(physicalValue int) int {
+	return permuteBits(physicalValue, res.FinalLayout, true)
+}
+
+/*
+Map a value of the logical qbits to the value of the physical qbits before the routed operations,
+e.g. to prepare an input state.
+*/
+func (res RoutingResult) PhysicalValue(logicalValue int) int {
+	return permuteBits(logicalValue, res.InitialLayout, false)
+}
+
+/*
+Return the value of a register of the original circuit from a value read from the physical qbits
+after the routed operations.
+*/
+func (res RoutingResult) RegisterValue(reg *Register, physicalValue int) int {
+	return reg.valueOf(uint(res.LogicalValue(physicalValue)))
+}
+
+/*
+Move the bits of val by a layout of logical qbit indexes to physical qbit indexes, or back when toLogical is true.
+*/
+func permuteBits(val int, layout []int, toLogical bool) int {
+	result := 0
+	for l, p := range layout {
+		if toLogical && val&(1<<uint(p)) != 0 {
+			result |= 1 << uint(l)
+		}
+		if !toLogical && val&(1<<uint(l)) != 0 {
+			result |= 1 << uint(p)
+		}
+	}
+	return result
+}
@@ -0,0 +1,412 @@
+package goqkit
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"time"
+)
+
+/*
+Largest number of qbits of order finding with a whole counting register,
+beyond it one counting qbit is measured and reused for every bit of the phase.
+*/
+const orderFindingMaxQBits = 20
+
+/*
+Largest probability that FindOrder gives up although every run of order finding works as expected.
+*/
+const orderFindingFailure = 1e-3
+
+/*
+Number of random bases which Shor tries.
+*/
+const shorAttempts = 10
+
+/*
+Multiply this register by a^x modulo N in place, where x is the value of register x,
+gcd(a, N) = 1 and the value of this register is less than N.
+
+The i-th qbit of x controls the multiplication by a^(2^i) mod N, see MultiplyMod.
+
+control: global control qbits value
+*/
+func (reg *Register) ModExp(x *Register, a int, N int, control int) error {
+	return reg.ModExpWith(x, a, N, control, AdderRipple)
+}
+
+/*
+ModExp with the adder of the modular additions.
+*/
+func (reg *Register) ModExpWith(x *Register, a int, N int, control int, adder Adder) error {
+	if N < 1 {
+		return fmt.Errorf("register %q: modulus %d is out of range", reg.Name, N)
+	}
+	if err := disjointRegisters(reg, x); err != nil {
+		return err
+	}
+	factor := ((a % N) + N) % N
+	for _, xq := range x.qbitList {
+		// the multiplication by 1 is the identity
+		if factor != 1 {
+			if err := reg.MultiplyModWith(factor, N, control|int(xq), adder); err != nil {
+				return err
+			}
+		}
+		factor = factor * factor % N
+	}
+	return nil
+}
+
+/*
+Estimate the phase s/r of the order r of a modulo N by phase estimation (order finding).
+
+The registers must be |0>. work is set to 1 and multiplied by a^x mod N for the superposition of
+all values x of count, then InversedQFT of count and measurement give m, where m/2^t is close to s/r
+for t qbits of count and a random s. n+2 free qbits are needed as ancillas for n qbits of work.
+
+Return the measured value m.
+*/
+func (q *QBitsCircuit) OrderFinding(count *Register, work *Register, a int, N int, adder Adder) (int, error) {
+	if err := disjointRegisters(count, work); err != nil {
+		return 0, err
+	}
+	if work.numberOfQBits == 0 {
+		return 0, fmt.Errorf("register %q has no qbits", work.Name)
+	}
+	q.Not(int(work.qbitList[0]), 0)
+	count.HadAll()
+	if err := work.ModExpWith(count, a, N, 0, adder); err != nil {
+		return 0, err
+	}
+	count.InversedQFT()
+	return count.ReadAll(), nil
+}
+
+/*
+OrderFinding with one counting qbit instead of a counting register (semiclassical QFT).
+
+The bits of m are estimated from the lowest one: the counting qbit controls the multiplication
+by a^(2^(t-1-j)) mod N for the j-th bit, the controlled phases of InversedQFT are applied by CIf
+on the bits measured before, and the qbit is measured into its own classical register and reset.
+So 2n+3 qbits are enough for n qbits of work, instead of t+2n+2.
+
+c: counting qbit, |0>
+
+t: number of bits of the phase
+*/
+func (q *QBitsCircuit) OrderFindingSemiClassical(c QBit, work *Register, t int, a int, N int, adder Adder) (int, error) {
+	mask, ok := q.maskOf(c)
+	if !ok {
+		return 0, q.Err()
+	}
+	if work.qBits&uint(mask) != 0 {
+		return 0, fmt.Errorf("counting qbit %d is a qbit of register %q", c.Index(), work.Name)
+	}
+	if work.numberOfQBits == 0 {
+		return 0, fmt.Errorf("register %q has no qbits", work.Name)
+	}
+	if N < 1 {
+		return 0, fmt.Errorf("modulus %d is out of range", N)
+	}
+	factors := make([]int, t)
+	factor := ((a % N) + N) % N
+	for k := range factors {
+		factors[k] = factor
+		factor = factor * factor % N
+	}
+
+	q.Not(int(work.qbitList[0]), 0)
+	var cregs []*ClassicalRegister
+	m := 0
+	for j := 0; j < t; j++ {
+		q.Had(mask, 0)
+		if f := factors[t-1-j]; f != 1 {
+			if err := work.MultiplyModWith(f, N, mask, adder); err != nil {
+				return 0, err
+			}
+		}
+		for l, creg := range cregs {
+			deg := 360 / float64(int(1)<<uint(j-l+1))
+			if err := q.CIf(creg, 1, func() { q.Phase(mask, 0, deg) }); err != nil {
+				return 0, err
+			}
+		}
+		q.Had(mask, 0)
+		creg := q.AssignClassicalBits(1, fmt.Sprintf("M%d", j))
+		r, err := q.Measure(mask, creg.GetBits())
+		if err != nil {
+			return 0, err
+		}
+		if r != 0 {
+			m |= 1 << uint(j)
+		}
+		q.Reset(mask)
+		cregs = append(cregs, creg)
+	}
+	return m, nil
+}
+
+/*
+Return the coefficients of the continued fraction of num/den, e.g. [0 1 3] for 3/4.
+*/
+func ContinuedFraction(num int, den int) []int {
+	var coefficients []int
+	for den != 0 {
+		coefficients = append(coefficients, num/den)
+		num, den = den, num%den
+	}
+	return coefficients
+}
+
+/*
+Return the convergents of the continued fraction of num/den as pairs of numerator and denominator,
+e.g. [[0 1] [1 1] [3 4]] for 3/4.
+*/
+func Convergents(num int, den int) [][2]int {
+	var convergents [][2]int
+	p0, q0, p1, q1 := 0, 1, 1, 0
+	for _, c := range ContinuedFraction(num, den) {
+		p0, q0, p1, q1 = p1, q1, c*p1+p0, c*q1+q0
+		convergents = append(convergents, [2]int{p1, q1})
+	}
+	return convergents
+}
+
+/*
+Return the period of a modulo N from the measured value m of order finding with t counting bits, or 0.
+
+The denominators of the convergents of m/2^t which are less than N are tried in order,
+and the first one r with a^r = 1 mod N is returned.
+It is a multiple of the order, 1 for a = 1 mod N, and 0 means that order finding has to be run again.
+*/
+func OrderFromPhase(m int, t int, a int, N int) int {
+	for _, c := range Convergents(m, 1<<uint(t)) {
+		r := c[1]
+		if r >= N {
+			break
+		}
+		if powMod(a, r, N) == 1 {
+			return r
+		}
+	}
+	return 0
+}
+
+/*
+Find the order r of a modulo N, the smallest r > 0 with a^r = 1 mod N, by order finding on a simulated circuit.
+
+The counting register has 2n+1 qbits for n bits of N. The circuit has a whole counting register
+if it fits in orderFindingMaxQBits qbits, otherwise one counting qbit (OrderFindingSemiClassical).
+Order finding runs again when no period is found in the measured value, and the denominators
+of the convergents of all runs are combined by their least common multiples.
+It gives up after orderFindingRuns runs.
+*/
+func FindOrder(a int, N int) (int, error) {
+	return FindOrderRand(a, N, nil)
+}
+
+/*
+FindOrder with the random values of the measurements from rnd, see SetRand.
+*/
+func FindOrderRand(a int, N int, rnd *rand.Rand) (int, error) {
+	if N < 2 {
+		return 0, fmt.Errorf("modulus %d is out of range", N)
+	}
+	if gcd(a, N) != 1 {
+		return 0, fmt.Errorf("%d is not invertible modulo %d", a, N)
+	}
+	if ((a%N)+N)%N == 1 {
+		return 1, nil
+	}
+	n := bits.Len(uint(N - 1))
+	t := 2*n + 1
+	runs := orderFindingRuns(N)
+	var denominators []int
+	for run := 0; run < runs; run++ {
+		var m int
+		var err error
+		if t+2*n+2 <= orderFindingMaxQBits {
+			circuit := MakeQBitsCircuit(t + 2*n + 2)
+			circuit.SetRand(rnd)
+			count := circuit.AssignQBits(t, "Count")
+			work := circuit.AssignQBits(n, "Work")
+			m, err = circuit.OrderFinding(count, work, a, N, AdderRipple)
+		} else {
+			circuit := MakeQBitsCircuit(2*n + 3)
+			circuit.SetRand(rnd)
+			c := circuit.AssignQBits(1, "Count")
+			work := circuit.AssignQBits(n, "Work")
+			m, err = circuit.OrderFindingSemiClassical(c.Q(0), work, t, a, N, AdderRipple)
+		}
+		if err != nil {
+			return 0, err
+		}
+		r := OrderFromPhase(m, t, a, N)
+		if r == 0 {
+			// s/r with a common factor gives a divisor of the order, so combine it with those of earlier runs
+			var current []int
+			for _, c := range Convergents(m, 1<<uint(t)) {
+				if c[1] > 1 && c[1] < N {
+					current = append(current, c[1])
+				}
+			}
+			for _, d := range current {
+				for _, e := range denominators {
+					if l := d / gcd(d, e) * e; l < N && powMod(a, l, N) == 1 {
+						r = l
+					}
+				}
+			}
+			denominators = append(denominators, current...)
+		}
+		if r != 0 {
+			// the period is a multiple of the order
+			for _, p := range primeFactors(r) {
+				for r%p == 0 && powMod(a, r/p, N) == 1 {
+					r /= p
+				}
+			}
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("order of %d modulo %d is not found in %d runs", a, N, runs)
+}
+
+/*
+Return the number of runs of order finding modulo N after which FindOrder fails
+with a probability of at most orderFindingFailure.
+
+One run measures a phase s/r with a random s, which is within 1/2^(t+1) of the closest one
+with a probability of at least 4/pi^2, and gives the order r itself when gcd(s, r) = 1,
+with a probability of phi(r)/r. So a run succeeds with a probability of at least
+p = 4/pi^2 min phi(r)/r over all orders r < N, and (1-p)^runs <= orderFindingFailure.
+Combining the runs only makes the failure less likely.
+*/
+func orderFindingRuns(N int) int {
+	ratio := 1.0
+	for r := 2; r < N; r++ {
+		phi := r
+		for _, p := range primeFactors(r) {
+			phi = phi / p * (p - 1)
+		}
+		ratio = math.Min(ratio, float64(phi)/float64(r))
+	}
+	p := 4 / (math.Pi * math.Pi) * ratio
+	return int(math.Ceil(math.Log(orderFindingFailure) / math.Log(1-p)))
+}
+
+/*
+Factor N into two nontrivial factors by Shor's algorithm.
+
+Even numbers and prime powers are factored classically. Otherwise a random base a is taken:
+gcd(a, N) > 1 is already a factor, and else the order r of a is found by FindOrder and
+gcd(a^(r/2)-1, N) is a factor when r is even and a^(r/2) is not -1 mod N.
+
+Return the factors p <= q with p*q = N.
+*/
+func Shor(N int) (int, int, error) {
+	return ShorRand(N, nil)
+}
+
+/*
+Shor with the random bases and the random values of the measurements from rnd, see SetRand.
+
+rnd: seeded by the time when nil
+*/
+func ShorRand(N int, rnd *rand.Rand) (int, int, error) {
+	if N < 4 {
+		return 0, 0, fmt.Errorf("%d has no nontrivial factors", N)
+	}
+	if N%2 == 0 {
+		return 2, N / 2, nil
+	}
+	if len(primeFactors(N)) == 1 {
+		p := primeFactors(N)[0]
+		if p == N {
+			return 0, 0, fmt.Errorf("%d is prime", N)
+		}
+		return p, N / p, nil
+	}
+
+	bases := rnd
+	if bases == nil {
+		bases = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	for attempt := 0; attempt < shorAttempts; attempt++ {
+		a := 2 + bases.Intn(N-3)
+		if g := gcd(a, N); g > 1 {
+			return sortedFactors(g, N/g)
+		}
+		r, err := FindOrderRand(a, N, rnd)
+		if err != nil || r%2 != 0 {
+			continue
+		}
+		y := powMod(a, r/2, N)
+		if y == N-1 {
+			continue
+		}
+		if g := gcd(y-1, N); g > 1 && g < N {
+			return sortedFactors(g, N/g)
+		}
+	}
+	return 0, 0, fmt.Errorf("%d is not factored in %d attempts", N, shorAttempts)
+}
+
+func sortedFactors(p int, q int) (int, int, error) {
+	if p > q {
+		p, q = q, p
+	}
+	return p, q, nil
+}
+
+/*
+Return the greatest common divisor of a and b.
+*/
+func gcd(a int, b int) int {
+	if a < 0 {
+		a = -a
+	}
+	if b < 0 {
+		b = -b
+	}
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+/*
+Return a^e mod m for e >= 0.
+*/
+func powMod(a int, e int, m int) int {
+	result := 1 % m
+	base := ((a % m) + m) % m
+	for ; e > 0; e >>= 1 {
+		if e&1 != 0 {
+			result = result * base % m
+		}
+		base = base * base % m
+	}
+	return result
+}
+
+/*
+Return the distinct prime factors of n > 1 in ascending order by trial division.
+*/
+func primeFactors(n int) []int {
+	var factors []int
+	for p := 2; p*p <= n; p++ {
+		if n%p == 0 {
+			factors = append(factors, p)
+			for n%p == 0 {
+				n /= p
+			}
+		}
+	}
+	if n > 1 {
+		factors = append(factors, n)
+	}
+	return factors
+}
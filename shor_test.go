@@ -0,0 +1,91 @@
+package goqkit_test
+
+import (
+	"fmt"
+	"github.com/takezo5096/goqkit"
+	"math/rand"
+	"testing"
+)
+
+func TestOrderFromPhase(t *testing.T) {
+	cases := []struct {
+		m, t, a, N, want int
+	}{
+		// 7 has the order 4 modulo 15, the phases are s/4
+		{0, 8, 7, 15, 0},
+		{64, 8, 7, 15, 4},
+		{128, 8, 7, 15, 0},
+		{192, 8, 7, 15, 4},
+		// a measured value near 1/3 of 2^11
+		{683, 11, 4, 21, 3},
+		{0, 5, 1, 15, 1},
+	}
+	for _, c := range cases {
+		if got := goqkit.OrderFromPhase(c.m, c.t, c.a, c.N); got != c.want {
+			t.Errorf("OrderFromPhase(%d, %d, %d, %d) = %d, expected %d", c.m, c.t, c.a, c.N, got, c.want)
+		}
+	}
+}
+
+/*
+Order finding with seeded measurements. N = 5, 7 and 15 use a whole counting register,
+N = 21 and 35 one counting qbit.
+*/
+func TestFindOrder(t *testing.T) {
+	cases := []struct {
+		a, N, want int
+	}{
+		{1, 15, 1},
+		{4, 5, 2},
+		{2, 5, 4},
+		{2, 7, 3},
+		{3, 7, 6},
+		{2, 21, 6},
+		{4, 21, 3},
+		{20, 21, 2},
+		{2, 15, 4},
+		{2, 35, 12},
+		{6, 35, 2},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%d mod %d", c.a, c.N), func(t *testing.T) {
+			r, err := goqkit.FindOrderRand(c.a, c.N, rand.New(rand.NewSource(1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r != c.want {
+				t.Errorf("order %d, expected %d", r, c.want)
+			}
+		})
+	}
+	if _, err := goqkit.FindOrder(6, 21); err == nil {
+		t.Error("order of 6 modulo 21 is found")
+	}
+}
+
+func TestShor(t *testing.T) {
+	cases := []struct {
+		N, p, q int
+	}{
+		{10, 2, 5},
+		{9, 3, 3},
+		{15, 3, 5},
+		{21, 3, 7},
+		{35, 5, 7},
+	}
+	for _, c := range cases {
+		p, q, err := goqkit.ShorRand(c.N, rand.New(rand.NewSource(1)))
+		if err != nil {
+			t.Errorf("Shor(%d): %v", c.N, err)
+			continue
+		}
+		if p != c.p || q != c.q {
+			t.Errorf("Shor(%d) = %d, %d, expected %d, %d", c.N, p, q, c.p, c.q)
+		}
+	}
+	for _, N := range []int{3, 7, 13} {
+		if _, _, err := goqkit.ShorRand(N, rand.New(rand.NewSource(1))); err == nil {
+			t.Errorf("prime %d is factored", N)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package goqkit
+
+import (
+	"fmt"
+	"github.com/takezo5096/goqkit/mat"
+	"math"
+	"math/cmplx"
+)
+
+/*
+Apply the 2x2 matrix m to the single target qbit of the state vector v.
+
+The matrix is only applied to the amplitudes whose control qbits are all 1.
+
+target: global target qbit value (only one bit)
+
+controlValue: global control qbits value
+*/
+func applyMatrix(v *mat.Vector, target uint, controlValue int, m *mat.Matrix) {
+	m00, m01, m10, m11 := m.At(0, 0), m.At(0, 1), m.At(1, 0), m.At(1, 1)
+	var i uint
+	for i = 0; i < v.N; i++ {
+		if i&target != 0 || int(i)&controlValue != controlValue {
+			continue
+		}
+		a0 := v.Data[i]
+		a1 := v.Data[i|target]
+		v.Data[i] = m00*a0 + m01*a1
+		v.Data[i|target] = m10*a0 + m11*a1
+	}
+}
+
+/*
+Make the matrix of the rotation gate. Only one of degX, degY and degZ is expected to be non-zero.
+*/
+func rotationMatrix(degX, degY, degZ float64) mat.Matrix {
+
+	thetaX := degX * (math.Pi / 180.0)
+	thetaY := degY * (math.Pi / 180.0)
+	thetaZ := degZ * (math.Pi / 180.0)
+
+	var v00, v01, v10, v11 complex128
+
+	v00 = 1
+	v01 = 0
+	v10 = 0
+	v11 = 1
+
+	if math.Abs(thetaX) > 0 {
+		v00 = complex(math.Cos(thetaX/2.0), 0)
+		v01 = complex(0, -math.Sin(thetaX/2.0))
+		v10 = complex(0, -math.Sin(thetaX/2.0))
+		v11 = complex(math.Cos(thetaX/2.0), 0)
+	}
+	if math.Abs(thetaY) > 0 {
+		v00 = complex(math.Cos(thetaY/2.0), 0)
+		v01 = complex(-math.Sin(thetaY/2.0), 0)
+		v10 = complex(math.Sin(thetaY/2.0), 0)
+		v11 = complex(math.Cos(thetaY/2.0), 0)
+	}
+	if math.Abs(thetaZ) > 0 {
+		v00 = cmplx.Exp(complex(0, -thetaZ/2.0))
+		v01 = complex(0, 0)
+		v10 = complex(0, 0)
+		v11 = cmplx.Exp(complex(0, thetaZ/2.0))
+	}
+
+	return newMatrix2(v00, v01, v10, v11)
+}
+
+/*
+Make the matrix of the phase gate.
+*/
+func phaseMatrix(deg float64) mat.Matrix {
+	theta := deg * (math.Pi / 180.0)
+	return newMatrix2(1, 0, 0, cmplx.Exp(complex(0, theta)))
+}
+
+/*
+Make the matrix of the SX gate.
+*/
+func sxMatrix() mat.Matrix {
+	return newMatrix2(complex(0.5, 0.5), complex(0.5, -0.5), complex(0.5, -0.5), complex(0.5, 0.5))
+}
+
+/*
+Make the matrix of the U3 gate.
+*/
+func u3Matrix(thetaDeg, phiDeg, lambdaDeg float64) mat.Matrix {
+	theta := thetaDeg * (math.Pi / 180.0)
+	phi := phiDeg * (math.Pi / 180.0)
+	lambda := lambdaDeg * (math.Pi / 180.0)
+	c := complex(math.Cos(theta/2), 0)
+	s := complex(math.Sin(theta/2), 0)
+	return newMatrix2(c, -cmplx.Exp(complex(0, lambda))*s, cmplx.Exp(complex(0, phi))*s, cmplx.Exp(complex(0, phi+lambda))*c)
+}
+
+/*
+Decompose a 2x2 unitary matrix as e^(i alpha) U3(theta, phi, lambda).
+
+Return theta, phi, lambda and alpha in degrees.
+*/
+func u3Angles(m mat.Matrix) (float64, float64, float64, float64) {
+	const eps = 1e-12
+	a00, a01, a10, a11 := m.At(0, 0), m.At(0, 1), m.At(1, 0), m.At(1, 1)
+	theta := 2 * math.Atan2(cmplx.Abs(a10), cmplx.Abs(a00))
+	var phi, lambda, alpha float64
+	switch {
+	case cmplx.Abs(a10) < eps:
+		alpha = cmplx.Phase(a00)
+		lambda = cmplx.Phase(a11) - alpha
+	case cmplx.Abs(a00) < eps:
+		alpha = cmplx.Phase(-a01)
+		phi = cmplx.Phase(a10) - alpha
+	default:
+		alpha = cmplx.Phase(a00)
+		phi = cmplx.Phase(a10) - alpha
+		lambda = cmplx.Phase(-a01) - alpha
+	}
+	toDeg := 180.0 / math.Pi
+	return theta * toDeg, phi * toDeg, lambda * toDeg, alpha * toDeg
+}
+
+func newMatrix2(v00, v01, v10, v11 complex128) mat.Matrix {
+	m := mat.NewMatrix(2, 2)
+	m.Set(0, 0, v00)
+	m.Set(0, 1, v01)
+	m.Set(1, 0, v10)
+	m.Set(1, 1, v11)
+	return m
+}
+
+/*
+Return the 2x2 matrix of a recorded single qbit operation.
+
+Swap, Read, Write and Space operations have no 2x2 matrix and return an error.
+*/
+func operationMatrix(op Operation) (mat.Matrix, error) {
+	sqrt2 := 1.0 / complex(math.Sqrt(2), 0)
+	switch op.OpName {
+	case OperationTypeHad:
+		return newMatrix2(sqrt2, sqrt2, sqrt2, -sqrt2), nil
+	case OperationTypeNot, OperationTypeX:
+		return newMatrix2(0, 1, 1, 0), nil
+	case OperationTypeY:
+		return newMatrix2(0, complex(0, -1), complex(0, 1), 0), nil
+	case OperationTypeZ:
+		return newMatrix2(1, 0, 0, -1), nil
+	case OperationTypeSX:
+		return sxMatrix(), nil
+	case OperationTypeRotate:
+		if len(op.Options) < 3 {
+			return mat.Matrix{}, fmt.Errorf("rotate operation needs 3 options, got %d", len(op.Options))
+		}
+		return rotationMatrix(op.Options[0], op.Options[1], op.Options[2]), nil
+	case OperationTypePhase:
+		if len(op.Options) < 1 {
+			return mat.Matrix{}, fmt.Errorf("phase operation needs 1 option, got %d", len(op.Options))
+		}
+		return phaseMatrix(op.Options[0]), nil
+	case OperationTypeU3:
+		if len(op.Options) < 3 {
+			return mat.Matrix{}, fmt.Errorf("u3 operation needs 3 options, got %d", len(op.Options))
+		}
+		return u3Matrix(op.Options[0], op.Options[1], op.Options[2]), nil
+	}
+	return mat.Matrix{}, fmt.Errorf("operation %q has no single qbit matrix", op.OpName)
+}
+
+/*
+Return the global control qbits value of a recorded operation.
+*/
+func (op Operation) ControlValue() int {
+	control := 0
+	for _, c := range op.ControlQBits {
+		control |= int(c)
+	}
+	return control
+}
+
+/*
+Return true if the operation is a unitary gate which can be replayed on a state vector.
+
+Gates with a condition on classical bits are not unitary.
+*/
+func (op Operation) IsUnitary() bool {
+	if op.Condition != nil {
+		return false
+	}
+	switch op.OpName {
+	case OperationTypeRead, OperationTypeWrite, OperationTypeReset:
+		return false
+	}
+	return true
+}
+
+/*
+Replay a recorded operation on the state vector v.
+
+inverse: apply the conjugate transpose of the operation instead
+
+registry: registry of the user defined gates, DefaultGateRegistry when nil
+*/
+func applyOperation(v *mat.Vector, op Operation, inverse bool, registry *GateRegistry) error {
+	control := op.ControlValue()
+	switch op.OpName {
+	case OperationTypeSpace:
+		return nil
+	case OperationTypeSwap:
+		// swap is made of three controlled nots and is its own inverse
+		not := newMatrix2(0, 1, 1, 0)
+		applyMatrix(v, op.TargetQBit, control|int(op.SwapQBit), &not)
+		applyMatrix(v, op.SwapQBit, control|int(op.TargetQBit), &not)
+		applyMatrix(v, op.TargetQBit, control|int(op.SwapQBit), &not)
+		return nil
+	case OperationTypeRead, OperationTypeWrite, OperationTypeReset:
+		return fmt.Errorf("operation %q is not unitary", op.OpName)
+	case OperationTypeGate:
+		name := op.GateName
+		if inverse {
+			name = inverseGateName(name)
+		}
+		if registry == nil {
+			registry = DefaultGateRegistry
+		}
+		def, ok := registry.Lookup(name)
+		if !ok {
+			return fmt.Errorf("gate %q is not defined", op.GateName)
+		}
+		return def.apply(v, op.TargetQBits, control)
+	}
+
+	m, err := operationMatrix(op)
+	if err != nil {
+		return err
+	}
+	if inverse {
+		m = conjugateTranspose2(m)
+	}
+	applyMatrix(v, op.TargetQBit, control, &m)
+	return nil
+}
+
+func conjugateTranspose2(m mat.Matrix) mat.Matrix {
+	return newMatrix2(cmplx.Conj(m.At(0, 0)), cmplx.Conj(m.At(1, 0)), cmplx.Conj(m.At(0, 1)), cmplx.Conj(m.At(1, 1)))
+}
+
+func copyVector(v mat.Vector) mat.Vector {
+	c := mat.NewVector(v.N)
+	copy(c.Data, v.Data)
+	return c
+}
@@ -0,0 +1,158 @@
+package goqkit
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strings"
+)
+
+/*
+Return the local value of this register in the basis state specified by index.
+
+index: index of RawQBits
+*/
+func (reg *Register) valueOf(index uint) int {
+	return reg.ToLocalQBits(int(index))
+}
+
+/*
+Return the index of RawQBits for the basis state which has the given register values.
+
+Qbits which are not in any of the registers are 0.
+*/
+func (q *QBitsCircuit) BasisIndex(values map[*Register]int) uint {
+	var index uint
+	for reg, val := range values {
+		index |= uint(reg.ToGlobalQBits(val)) & reg.qBits
+	}
+	return index
+}
+
+/*
+Return the amplitude of the basis state which has the given register values.
+
+Example: Amplitude(map[*Register]int{a: 3, b: 1})
+*/
+func (q *QBitsCircuit) Amplitude(values map[*Register]int) complex128 {
+	return q.RawQBits.At(q.BasisIndex(values))
+}
+
+/*
+Return the probabilities of all basis states, indexed in the same way as RawQBits.
+*/
+func (q *QBitsCircuit) Probabilities() []float64 {
+	probs := make([]float64, q.RawQBits.N)
+	for i, a := range q.RawQBits.Data {
+		probs[i] = real(a)*real(a) + imag(a)*imag(a)
+	}
+	return probs
+}
+
+/*
+Return the joint probability distribution over the values of the registers.
+
+The first register takes the lowest bits of the returned index, the next register the following bits, and so on.
+
+Example: for 2 qbits register a and 1 qbit register b, element (va | vb<<2) is the probability of a=va and b=vb.
+*/
+func (q *QBitsCircuit) MarginalProbabilities(regs ...*Register) []float64 {
+	width := 0
+	for _, reg := range regs {
+		width += reg.NumberOfQBits()
+	}
+	marginal := make([]float64, 1<<width)
+	for i, p := range q.Probabilities() {
+		if p == 0 {
+			continue
+		}
+		idx := 0
+		offset := 0
+		for _, reg := range regs {
+			idx |= reg.valueOf(uint(i)) << offset
+			offset += reg.NumberOfQBits()
+		}
+		marginal[idx] += p
+	}
+	return marginal
+}
+
+/*
+Return the Bloch vector (x, y, z) of a qbit.
+
+The length of the vector is less than 1 when the qbit is entangled with other qbits.
+
+qbit: global qbit value (only one bit)
+*/
+func (q *QBitsCircuit) BlochVector(qbit uint) (float64, float64, float64) {
+	var rho00, rho11 float64
+	var rho01 complex128
+	var i uint
+	for i = 0; i < q.RawQBits.N; i++ {
+		if i&qbit != 0 {
+			continue
+		}
+		a0 := q.RawQBits.At(i)
+		a1 := q.RawQBits.At(i | qbit)
+		rho00 += real(a0)*real(a0) + imag(a0)*imag(a0)
+		rho11 += real(a1)*real(a1) + imag(a1)*imag(a1)
+		rho01 += a0 * cmplx.Conj(a1)
+	}
+	return 2 * real(rho01), -2 * imag(rho01), rho00 - rho11
+}
+
+/*
+Return the state in Dirac notation like "0.71|00⟩ + 0.71|11⟩".
+
+Basis states are written with the highest qbit on the left and
+those whose amplitude is not larger than threshold in absolute value are omitted.
+*/
+func (q *QBitsCircuit) DiracString(threshold float64) string {
+	var sb strings.Builder
+	for i, a := range q.RawQBits.Data {
+		if cmplx.Abs(a) <= threshold {
+			continue
+		}
+		s := formatAmplitude(a)
+		if sb.Len() == 0 {
+			sb.WriteString(s)
+		} else if strings.HasPrefix(s, "-") {
+			sb.WriteString(" - " + s[1:])
+		} else {
+			sb.WriteString(" + " + s)
+		}
+		sb.WriteString(fmt.Sprintf("|%0*b⟩", int(q.QBitNumber), i))
+	}
+	if sb.Len() == 0 {
+		return "0"
+	}
+	return sb.String()
+}
+
+func formatAmplitude(a complex128) string {
+	const eps = 0.005
+	re, im := real(a), imag(a)
+	switch {
+	case math.Abs(im) < eps:
+		return fmt.Sprintf("%.2f", re)
+	case math.Abs(re) < eps:
+		return fmt.Sprintf("%.2fi", im)
+	}
+	return fmt.Sprintf("(%.2f%+.2fi)", re, im)
+}
+
+/*
+Return the probabilities of all values of this register.
+*/
+func (reg *Register) Probabilities() []float64 {
+	return reg.circuit.MarginalProbabilities(reg)
+}
+
+/*
+Return the Bloch vector (x, y, z) of the qbit specified as val.
+
+val: local qbit value (only one bit)
+*/
+func (reg *Register) BlochVector(val int) (float64, float64, float64) {
+	return reg.circuit.BlochVector(uint(reg.ToGlobalQBits(val)))
+}
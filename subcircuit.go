@@ -0,0 +1,223 @@
+package goqkit
+
+import (
+	"fmt"
+)
+
+/*
+A block of gate operations which can be applied to a circuit many times,
+inverted and controlled by additional qbits.
+
+Operations refer to global qbits of the circuit where they were captured.
+*/
+type SubCircuit struct {
+	operations []Operation
+}
+
+/*
+Capture the gates which f applies to this circuit as a sub circuit.
+
+While f runs, gates are recorded into the sub circuit instead of the circuit
+and the qbits are not changed. f may call any gate, including QFT, Add, Grover and Apply,
+but no Read or Write, which make an error.
+*/
+func (q *QBitsCircuit) Capture(f func()) (*SubCircuit, error) {
+	saved := q.operations
+	q.operations = nil
+	q.capturing++
+	defer func() {
+		q.capturing--
+		q.operations = saved
+	}()
+
+	f()
+
+	for k, op := range q.operations {
+		if !op.IsUnitary() {
+			return nil, fmt.Errorf("captured operation %d: operation %q is not unitary", k, op.OpName)
+		}
+	}
+	return &SubCircuit{operations: q.operations}, nil
+}
+
+/*
+Make a sub circuit of recorded operations.
+*/
+func NewSubCircuit(ops []Operation) (*SubCircuit, error) {
+	for k, op := range ops {
+		if !op.IsUnitary() {
+			return nil, fmt.Errorf("operation %d: operation %q is not unitary", k, op.OpName)
+		}
+	}
+	return &SubCircuit{operations: append([]Operation{}, ops...)}, nil
+}
+
+/*
+Return the operations of this sub circuit.
+*/
+func (s *SubCircuit) Operations() []Operation {
+	return s.operations
+}
+
+/*
+Return the global value of all qbits which this sub circuit acts on.
+*/
+func (s *SubCircuit) QBits() int {
+	val := 0
+	for _, op := range s.operations {
+		for _, qb := range op.QBits() {
+			val |= int(qb)
+		}
+	}
+	return val
+}
+
+/*
+Return the inverse (dagger) of this sub circuit: the inverse operations in reverse order.
+*/
+func (s *SubCircuit) Inverse() *SubCircuit {
+	ops := make([]Operation, 0, len(s.operations))
+	for i := len(s.operations) - 1; i >= 0; i-- {
+		ops = append(ops, inverseOperations(s.operations[i])...)
+	}
+	return &SubCircuit{operations: ops}
+}
+
+/*
+Return this sub circuit with the global control qbits value added to the controls of every operation.
+*/
+func (s *SubCircuit) Controlled(controlValue int) (*SubCircuit, error) {
+	ops := make([]Operation, 0, len(s.operations))
+	for k, op := range s.operations {
+		c, err := controlledOperation(op, controlValue)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %v", k, err)
+		}
+		ops = append(ops, c)
+	}
+	return &SubCircuit{operations: ops}, nil
+}
+
+/*
+Return this sub circuit repeated k times, or its inverse repeated -k times when k is negative.
+*/
+func (s *SubCircuit) Power(k int) *SubCircuit {
+	base := s
+	if k < 0 {
+		base = s.Inverse()
+		k = -k
+	}
+	ops := make([]Operation, 0, k*len(base.operations))
+	for i := 0; i < k; i++ {
+		ops = append(ops, base.operations...)
+	}
+	return &SubCircuit{operations: ops}
+}
+
+/*
+Return the inverse of an operation as one or more operations with the same controls.
+*/
+func inverseOperations(op Operation) []Operation {
+	inv := op
+	switch op.OpName {
+	case OperationTypePhase:
+		inv.Options = []float64{-optionAt(op, 0)}
+	case OperationTypeRotate:
+		axis := rotationAxisIndex(op)
+		inv.Options = rotateOptions(axis, -optionAt(op, axis))
+	case OperationTypeU3:
+		// U3(theta, phi, lambda)^dagger = U3(-theta, -lambda, -phi)
+		inv.Options = []float64{-optionAt(op, 0), -optionAt(op, 2), -optionAt(op, 1)}
+	case OperationTypeGate:
+		inv.GateName = inverseGateName(op.GateName)
+	case OperationTypeSX:
+		// SX^dagger = SX^3 = X SX
+		x := op
+		x.OpName = OperationTypeX
+		return []Operation{op, x}
+	}
+	return []Operation{inv}
+}
+
+/*
+Return the operation with the global control qbits value added to its controls.
+*/
+func controlledOperation(op Operation, controlValue int) (Operation, error) {
+	if controlValue == 0 || op.OpName == OperationTypeSpace {
+		return op, nil
+	}
+	for _, qb := range op.QBits() {
+		if int(qb)&controlValue != 0 {
+			return op, fmt.Errorf("control qbit %d is also used by operation %q", qbitIndex(qb), op.OpName)
+		}
+	}
+	op.ControlQBits = qbitList(op.ControlValue() | controlValue)
+	return op, nil
+}
+
+/*
+Apply a sub circuit to this circuit with additional global control qbits.
+
+The gates change the qbits and are recorded like gates called directly.
+
+controlValue: global control qbits value, 0 for no additional control
+*/
+func (q *QBitsCircuit) Apply(s *SubCircuit, controlValue int) error {
+	controlled, err := s.Controlled(controlValue)
+	if err != nil {
+		return err
+	}
+	for _, op := range controlled.operations {
+		if err := q.applyRecorded(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Apply the inverse of a sub circuit with additional global control qbits.
+
+See Apply.
+*/
+func (q *QBitsCircuit) ApplyInverse(s *SubCircuit, controlValue int) error {
+	return q.Apply(s.Inverse(), controlValue)
+}
+
+/*
+Run a recorded operation by calling its gate.
+*/
+func (q *QBitsCircuit) applyRecorded(op Operation) error {
+	target := int(op.TargetQBit)
+	control := op.ControlValue()
+	switch op.OpName {
+	case OperationTypeSpace:
+		q.operations = append(q.operations, op)
+	case OperationTypeHad:
+		q.Had(target, control)
+	case OperationTypeNot:
+		q.Not(target, control)
+	case OperationTypeX:
+		q.X(target, control)
+	case OperationTypeY:
+		q.Y(target, control)
+	case OperationTypeZ:
+		q.Z(target, control)
+	case OperationTypeSX:
+		q.SX(target, control)
+	case OperationTypePhase:
+		q.Phase(target, control, optionAt(op, 0))
+	case OperationTypeRotate:
+		axis := rotationAxisIndex(op)
+		q.rotImpl(target, control, axis, optionAt(op, axis))
+	case OperationTypeU3:
+		q.U3(target, control, optionAt(op, 0), optionAt(op, 1), optionAt(op, 2))
+	case OperationTypeSwap:
+		q.Swap(target, int(op.SwapQBit), control)
+	case OperationTypeGate:
+		return q.gate(op.GateName, op.TargetQBits, control)
+	default:
+		return fmt.Errorf("operation %q can not be applied", op.OpName)
+	}
+	return nil
+}
@@ -0,0 +1,752 @@
+package goqkit
+
+import (
+	"fmt"
+	"github.com/takezo5096/goqkit/mat"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Names of the gates which a transpiler basis can contain.
+*/
+const (
+	//Not with one control
+	BasisCX = "cx"
+	//Z with one control
+	BasisCZ = "cz"
+	//Uncontrolled Not
+	BasisX = "x"
+	//Square root of X
+	BasisSX = "sx"
+	//Rotation about the Z axis
+	BasisRZ = "rz"
+	//Rotation about the X axis
+	BasisRX = "rx"
+	//Rotation about the Y axis
+	BasisRY = "ry"
+	//Phase gate, which is RZ up to a global phase
+	BasisP = "p"
+	//Hadamard gate
+	BasisH = "h"
+	//General single qbit gate
+	BasisU3 = "u3"
+)
+
+/*
+The native gate set of IBM style hardware: CX, RZ, SX and X.
+*/
+var NativeBasis = []string{BasisCX, BasisRZ, BasisSX, BasisX}
+
+/*
+Options of Transpile.
+*/
+type TranspileOptions struct {
+	//Gates of the target basis (Basis* constants), NativeBasis when empty
+	Basis []string
+	//Global value of qbits which are |0> and not used by any operation.
+	//Multi-controlled gates use them as clean ancillas where this gives a shorter decomposition,
+	//and leave them in |0>.
+	Ancillas int
+	//Check that the result implements the same unitary as the operations up to a global phase
+	//(on the subspace where the ancillas are |0>)
+	Verify bool
+	//Registry of the user defined gates, DefaultGateRegistry when nil
+	//(the registry of the circuit for QBitsCircuit.Transpile)
+	Gates *GateRegistry
+}
+
+/*
+Transpile the operations recorded in this circuit.
+
+See Transpile. The register of each resulting operation is the register of its target qbit.
+*/
+func (q *QBitsCircuit) Transpile(opts TranspileOptions) ([]Operation, error) {
+	if opts.Gates == nil {
+		opts.Gates = q.GateRegistry()
+	}
+	ops, err := Transpile(q.QBitNumber, q.GetOperations(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ops {
+		if reg := q.GetRegister(int(ops[i].TargetQBit)); reg != nil {
+			ops[i].RegisterName = 1 << reg.shift
+			ops[i].RegisterNameString = reg.Name
+		}
+	}
+	return ops, nil
+}
+
+/*
+Decompose operations on n qbits into the gates of a basis.
+
+Gates with any number of controls, including the multi-controlled Not, Phase and rotations
+made by Add, Subtract and Grover, and controlled Swaps are decomposed into Toffolis and
+singly controlled gates, then into the two qbit gate of the basis (cx or cz) and single qbit basis gates.
+Qbits which a multi-controlled gate does not act on are borrowed as dirty ancillas and restored,
+and the clean ancillas of opts are used where they give a shorter decomposition. A gate with k controls needs O(k)
+gates when some qbit is free and O(k^2) gates otherwise.
+
+Read, Write and Space operations are kept. The result is equal up to a global phase.
+
+The basis needs cx or cz, and u3, or rz (or p) together with sx, rx, ry or h, or both rx and ry.
+*/
+func Transpile(n uint, ops []Operation, opts TranspileOptions) ([]Operation, error) {
+	t, err := newTranspiler(n, opts)
+	if err != nil {
+		return nil, err
+	}
+	expanded, err := ExpandOperations(ops, opts.Gates)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, op := range expanded {
+		for _, qb := range op.QBits() {
+			if int(qb)&opts.Ancillas != 0 {
+				return nil, fmt.Errorf("operation %d: qbit %d is an ancilla", k, qbitIndex(qb))
+			}
+		}
+		if err := t.operation(op); err != nil {
+			return nil, fmt.Errorf("operation %d: %v", k, err)
+		}
+	}
+
+	result, _ := Optimize(n, t.out, RemoveIdentityRotations, CancelInversePairs, MergeRotations)
+
+	if opts.Verify {
+		if err := verifyTranspiled(n, expanded, result, opts.Ancillas); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+type transpiler struct {
+	n     uint
+	basis map[string]bool
+	clean []uint
+	//operation which is being decomposed, the register of new operations is copied from it
+	src Operation
+	out []Operation
+}
+
+func newTranspiler(n uint, opts TranspileOptions) (*transpiler, error) {
+	names := opts.Basis
+	if len(names) == 0 {
+		names = NativeBasis
+	}
+	basis := make(map[string]bool)
+	for _, name := range names {
+		switch name {
+		case BasisCX, BasisCZ, BasisX, BasisSX, BasisRZ, BasisRX, BasisRY, BasisP, BasisH, BasisU3:
+			basis[name] = true
+		default:
+			return nil, fmt.Errorf("unknown basis gate %q", name)
+		}
+	}
+
+	z := basis[BasisRZ] || basis[BasisP]
+	universal := basis[BasisU3] || (z && (basis[BasisSX] || basis[BasisRX] || basis[BasisRY] || basis[BasisH])) ||
+		(basis[BasisRX] && basis[BasisRY])
+	if !universal || !(basis[BasisCX] || basis[BasisCZ]) {
+		return nil, fmt.Errorf("basis [%s] cannot express every gate", strings.Join(names, ", "))
+	}
+
+	return &transpiler{n: n, basis: basis, clean: qbitList(opts.Ancillas)}, nil
+}
+
+/*
+Return the single qbit values of a global qbits value in ascending order.
+*/
+func qbitList(val int) []uint {
+	var qbits []uint
+	for i := 0; val>>i != 0; i++ {
+		if val&(1<<i) != 0 {
+			qbits = append(qbits, 1<<i)
+		}
+	}
+	return qbits
+}
+
+func (t *transpiler) operation(op Operation) error {
+	t.src = op
+	// a conditional gate is decomposed like the plain gate and every emitted gate gets its condition
+	op.Condition = nil
+	if !op.IsUnitary() || op.OpName == OperationTypeSpace {
+		t.out = append(t.out, t.src)
+		return nil
+	}
+
+	controls := op.ControlQBits
+	switch op.OpName {
+	case OperationTypeSwap:
+		if op.SwapQBit == 0 || op.SwapQBit == op.TargetQBit {
+			return nil
+		}
+		// the middle Not is controlled by the target and the controls of the swap
+		mid := append(append([]uint{}, controls...), op.TargetQBit)
+		t.cx(op.SwapQBit, op.TargetQBit)
+		t.mcx(mid, op.SwapQBit, t.dirty(append(mid, op.SwapQBit)), t.clean)
+		t.cx(op.SwapQBit, op.TargetQBit)
+		return nil
+	case OperationTypeNot, OperationTypeX:
+		t.mcx(controls, op.TargetQBit, t.dirty(op.QBits()), t.clean)
+		return nil
+	}
+
+	m, err := operationMatrix(op)
+	if err != nil {
+		return err
+	}
+	t.mcu(controls, op.TargetQBit, m, t.dirty(op.QBits()), t.clean)
+	return nil
+}
+
+/*
+Return the qbits which are not used and not clean ancillas, which can be borrowed in any state.
+*/
+func (t *transpiler) dirty(used []uint) []uint {
+	mask := 0
+	for _, qb := range used {
+		mask |= int(qb)
+	}
+	for _, qb := range t.clean {
+		mask |= int(qb)
+	}
+	var free []uint
+	var i uint
+	for i = 0; i < t.n; i++ {
+		if mask&(1<<i) == 0 {
+			free = append(free, 1<<i)
+		}
+	}
+	return free
+}
+
+/*
+Not on target controlled by all controls.
+
+dirty: qbits in any state which may be used and are restored
+
+clean: qbits in |0> which may be used and are restored
+*/
+func (t *transpiler) mcx(controls []uint, target uint, dirty, clean []uint) {
+	m := len(controls)
+	switch {
+	case m == 0:
+		t.single(target, newMatrix2(0, 1, 1, 0))
+	case m == 1:
+		t.cx(controls[0], target)
+	case m == 2:
+		t.toffoli(controls[0], controls[1], target)
+	case len(clean) >= m-2:
+		// compute the AND of the controls into the ancillas, apply and uncompute
+		a := clean[:m-2]
+		var chain [][3]uint
+		chain = append(chain, [3]uint{controls[0], controls[1], a[0]})
+		for i := 2; i < m-1; i++ {
+			chain = append(chain, [3]uint{controls[i], a[i-2], a[i-1]})
+		}
+		for _, g := range chain {
+			t.toffoli(g[0], g[1], g[2])
+		}
+		t.toffoli(controls[m-1], a[m-3], target)
+		for i := len(chain) - 1; i >= 0; i-- {
+			t.toffoli(chain[i][0], chain[i][1], chain[i][2])
+		}
+	case len(dirty)+len(clean) >= m-2:
+		t.mcxDirty(controls, target, append(append([]uint{}, dirty...), clean...)[:m-2])
+	case len(dirty)+len(clean) >= 1:
+		// split the controls in two halves which borrow each other (Barenco et al. Lemma 7.3)
+		pool := append(append([]uint{}, dirty...), clean...)
+		a := pool[0]
+		m1 := (m + 1) / 2
+		g1 := controls[:m1]
+		g2 := append(append([]uint{}, controls[m1:]...), a)
+		free1 := append(append(append([]uint{}, g2[:len(g2)-1]...), target), pool[1:]...)
+		free2 := append(append([]uint{}, g1...), pool[1:]...)
+		for i := 0; i < 2; i++ {
+			t.mcx(g1, a, free1, nil)
+			t.mcx(g2, target, free2, nil)
+		}
+	default:
+		t.mcuNoAncilla(controls, target, newMatrix2(0, 1, 1, 0), nil)
+	}
+}
+
+/*
+Not with m controls using m-2 dirty ancillas (Barenco et al. Lemma 7.2).
+*/
+func (t *transpiler) mcxDirty(controls []uint, target uint, a []uint) {
+	m := len(controls)
+	// ladder of Toffolis from the target down to the first two controls and back up
+	ladder := func(withTarget bool) {
+		if withTarget {
+			t.toffoli(controls[m-1], a[m-3], target)
+		}
+		for i := m - 2; i >= 2; i-- {
+			t.toffoli(controls[i], a[i-2], a[i-1])
+		}
+		t.toffoli(controls[0], controls[1], a[0])
+		for i := 2; i <= m-2; i++ {
+			t.toffoli(controls[i], a[i-2], a[i-1])
+		}
+		if withTarget {
+			t.toffoli(controls[m-1], a[m-3], target)
+		}
+	}
+	ladder(true)
+	ladder(false)
+}
+
+/*
+Single qbit gate u on target controlled by all controls.
+*/
+func (t *transpiler) mcu(controls []uint, target uint, u mat.Matrix, dirty, clean []uint) {
+	m := len(controls)
+	switch {
+	case isNotMatrix(u):
+		t.mcx(controls, target, dirty, clean)
+	case m == 0:
+		t.single(target, u)
+	case m == 1:
+		t.controlled(controls[0], target, u)
+	case m >= 4 && len(clean) >= 1:
+		// the AND of the controls in a clean ancilla is not always shorter than the decomposition without it
+		t.shortest(func() {
+			a := clean[0]
+			free := append(append([]uint{}, dirty...), target)
+			t.mcx(controls, a, free, clean[1:])
+			t.controlled(a, target, u)
+			t.mcx(controls, a, free, clean[1:])
+		}, func() {
+			// clean ancillas can be borrowed like dirty ones
+			t.mcuNoAncilla(controls, target, u, append(append([]uint{}, dirty...), clean...))
+		})
+	default:
+		t.mcuNoAncilla(controls, target, u, dirty)
+	}
+}
+
+/*
+Emit the gates of the decomposition with the fewest two qbit gates, and then the fewest gates.
+*/
+func (t *transpiler) shortest(decompositions ...func()) {
+	out := t.out
+	var best []Operation
+	for k, f := range decompositions {
+		t.out = nil
+		f()
+		if k == 0 || cheaperOperations(t.out, best) {
+			best = t.out
+		}
+	}
+	t.out = append(out, best...)
+}
+
+/*
+Return true if a has fewer two qbit gates than b, or as many and fewer gates.
+*/
+func cheaperOperations(a, b []Operation) bool {
+	twoQBits := func(ops []Operation) int {
+		n := 0
+		for _, op := range ops {
+			if len(op.ControlQBits) > 0 {
+				n++
+			}
+		}
+		return n
+	}
+	if na, nb := twoQBits(a), twoQBits(b); na != nb {
+		return na < nb
+	}
+	return len(a) < len(b)
+}
+
+/*
+Single qbit gate u on target controlled by m >= 2 controls without clean ancillas (Barenco et al. Lemma 7.5).
+
+With V^2 = u, the last control applies V, the other controls flip the last control
+around a controlled V^dagger, and the other controls apply V recursively.
+*/
+func (t *transpiler) mcuNoAncilla(controls []uint, target uint, u mat.Matrix, dirty []uint) {
+	m := len(controls)
+	last := controls[m-1]
+	rest := controls[:m-1]
+	v := sqrtMatrix2(u)
+
+	free := append(append([]uint{}, dirty...), target)
+	t.controlled(last, target, v)
+	t.mcx(rest, last, free, nil)
+	t.controlled(last, target, conjugateTranspose2(v))
+	t.mcx(rest, last, free, nil)
+	t.mcu(rest, target, v, append(append([]uint{}, dirty...), last), nil)
+}
+
+/*
+Toffoli gate with 6 controlled Nots and T gates.
+*/
+func (t *transpiler) toffoli(c1, c2, target uint) {
+	h := hadamardMatrix()
+	tg := phaseMatrix(45)
+	tdg := phaseMatrix(-45)
+
+	t.single(target, h)
+	t.cx(c2, target)
+	t.single(target, tdg)
+	t.cx(c1, target)
+	t.single(target, tg)
+	t.cx(c2, target)
+	t.single(target, tdg)
+	t.cx(c1, target)
+	t.single(c2, tg)
+	t.single(target, tg)
+	t.single(target, h)
+	t.cx(c1, c2)
+	t.single(c1, tg)
+	t.single(c2, tdg)
+	t.cx(c1, c2)
+}
+
+/*
+Single qbit gate u on target controlled by one control.
+
+With u = e^(i alpha) RZ(beta) RY(gamma) RZ(delta), u is A X B X C on the target
+and a phase of alpha on the control, where ABC = I.
+*/
+func (t *transpiler) controlled(control, target uint, u mat.Matrix) {
+	theta, phi, lambda, alpha := u3Angles(u)
+	// U3(theta, phi, lambda) = e^(i (phi+lambda)/2) RZ(phi) RY(theta) RZ(lambda)
+	alpha += (phi + lambda) / 2
+	if isNotMatrix(phaseless(u)) {
+		alpha = cmplx.Phase(u.At(1, 0)) * 180 / math.Pi
+		t.cx(control, target)
+		t.single(control, phaseMatrix(alpha))
+		return
+	}
+
+	a := rotationMatrix(0, theta/2, 0)
+	rz := rotationMatrix(0, 0, phi)
+	a = rz.Mul(&a)
+	b := rotationMatrix(0, 0, -(lambda+phi)/2)
+	ry := rotationMatrix(0, -theta/2, 0)
+	b = ry.Mul(&b)
+	c := rotationMatrix(0, 0, (lambda-phi)/2)
+
+	t.single(target, c)
+	t.cx(control, target)
+	t.single(target, b)
+	t.cx(control, target)
+	t.single(target, a)
+	t.single(control, phaseMatrix(alpha))
+}
+
+func (t *transpiler) cx(control, target uint) {
+	if t.basis[BasisCX] {
+		t.emit(OperationTypeNot, target, control, nil)
+		return
+	}
+	h := hadamardMatrix()
+	t.single(target, h)
+	t.emit(OperationTypeZ, target, control, nil)
+	t.single(target, h)
+}
+
+/*
+Uncontrolled single qbit gate u in the single qbit gates of the basis, up to a global phase.
+*/
+func (t *transpiler) single(target uint, u mat.Matrix) {
+	if isIdentityUpToPhase(u) {
+		return
+	}
+	switch {
+	case t.basis[BasisX] && isNotMatrix(phaseless(u)):
+		t.emit(OperationTypeX, target, 0, nil)
+		return
+	case t.basis[BasisSX] && matricesEqualUpToPhase(u, sxMatrix()):
+		t.emit(OperationTypeSX, target, 0, nil)
+		return
+	case t.basis[BasisH] && matricesEqualUpToPhase(u, hadamardMatrix()):
+		t.emit(OperationTypeHad, target, 0, nil)
+		return
+	case t.basis[BasisU3]:
+		theta, phi, lambda, _ := u3Angles(u)
+		t.emit(OperationTypeU3, target, 0, []float64{theta, phi, lambda})
+		return
+	}
+
+	theta, phi, lambda, _ := u3Angles(u)
+	z := t.basis[BasisRZ] || t.basis[BasisP]
+	switch {
+	case math.Abs(theta) < 1e-9:
+		t.rz(target, phi+lambda)
+	case z && t.basis[BasisSX]:
+		// U3 = RZ(phi+180) SX RZ(theta+180) SX RZ(lambda) up to a global phase
+		if math.Abs(theta-90) < 1e-9 {
+			t.rz(target, lambda-90)
+			t.emit(OperationTypeSX, target, 0, nil)
+			t.rz(target, phi+90)
+			return
+		}
+		t.rz(target, lambda)
+		t.emit(OperationTypeSX, target, 0, nil)
+		t.rz(target, theta+180)
+		t.emit(OperationTypeSX, target, 0, nil)
+		t.rz(target, phi+180)
+	case t.basis[BasisRY]:
+		t.rz(target, lambda)
+		t.rotate(target, 1, normalizeDegree(theta))
+		t.rz(target, phi)
+	case z && t.basis[BasisRX]:
+		// RY(theta) = RZ(90) RX(theta) RZ(-90)
+		t.rz(target, lambda-90)
+		t.rotate(target, 0, normalizeDegree(theta))
+		t.rz(target, phi+90)
+	default:
+		// RX(theta) = H RZ(theta) H
+		t.rz(target, lambda-90)
+		t.emit(OperationTypeHad, target, 0, nil)
+		t.rz(target, theta)
+		t.emit(OperationTypeHad, target, 0, nil)
+		t.rz(target, phi+90)
+	}
+}
+
+/*
+Rotation about the Z axis up to a global phase.
+*/
+func (t *transpiler) rz(target uint, deg float64) {
+	deg = normalizeDegree(deg)
+	switch {
+	case math.Abs(deg) < 1e-9:
+	case t.basis[BasisRZ]:
+		t.rotate(target, 2, deg)
+	case t.basis[BasisP]:
+		t.emit(OperationTypePhase, target, 0, []float64{deg})
+	default:
+		// RZ(deg) = RX(90) RY(deg) RX(-90)
+		t.rotate(target, 0, -90)
+		t.rotate(target, 1, deg)
+		t.rotate(target, 0, 90)
+	}
+}
+
+/*
+Rotation about the axis, 0 for X, 1 for Y and 2 for Z.
+*/
+func (t *transpiler) rotate(target uint, axis int, deg float64) {
+	t.emit(OperationTypeRotate, target, 0, rotateOptions(axis, deg))
+	t.out[len(t.out)-1].Axis = rotationAxes[axis]
+}
+
+func (t *transpiler) emit(opName string, target uint, control uint, options []float64) {
+	op := Operation{OpName: opName, RegisterName: t.src.RegisterName, RegisterNameString: t.src.RegisterNameString,
+		TargetQBit: target, Options: options, Condition: t.src.Condition}
+	if control != 0 {
+		op.ControlQBits = []uint{control}
+	}
+	t.out = append(t.out, op)
+}
+
+/*
+Return the angle in degrees in (-180, 180].
+*/
+func normalizeDegree(deg float64) float64 {
+	deg = math.Mod(deg, 360)
+	if deg > 180 {
+		deg -= 360
+	} else if deg <= -180 {
+		deg += 360
+	}
+	return deg
+}
+
+func hadamardMatrix() mat.Matrix {
+	sqrt2 := 1.0 / complex(math.Sqrt(2), 0)
+	return newMatrix2(sqrt2, sqrt2, sqrt2, -sqrt2)
+}
+
+/*
+Return true if m is exactly the Not matrix.
+*/
+func isNotMatrix(m mat.Matrix) bool {
+	const eps = 1e-9
+	return cmplx.Abs(m.At(0, 0)) < eps && cmplx.Abs(m.At(1, 1)) < eps &&
+		cmplx.Abs(m.At(0, 1)-1) < eps && cmplx.Abs(m.At(1, 0)-1) < eps
+}
+
+/*
+Return m divided by the phase of its first non-zero column entry, so that equal matrices up to a phase become equal.
+*/
+func phaseless(m mat.Matrix) mat.Matrix {
+	p := m.At(0, 0)
+	if cmplx.Abs(p) < 1e-9 {
+		p = m.At(1, 0)
+	}
+	p = p / complex(cmplx.Abs(p), 0)
+	return newMatrix2(m.At(0, 0)/p, m.At(0, 1)/p, m.At(1, 0)/p, m.At(1, 1)/p)
+}
+
+func matricesEqualUpToPhase(a, b mat.Matrix) bool {
+	return matricesEqual(&a, &b, true)
+}
+
+/*
+Return a square root of a 2x2 unitary matrix.
+
+sqrt(M) = (M + sI) / sqrt(tr M + 2s) with s^2 = det M.
+*/
+func sqrtMatrix2(m mat.Matrix) mat.Matrix {
+	det := m.At(0, 0)*m.At(1, 1) - m.At(0, 1)*m.At(1, 0)
+	s := cmplx.Sqrt(det)
+	tr := m.At(0, 0) + m.At(1, 1)
+	if cmplx.Abs(tr+2*s) < cmplx.Abs(tr-2*s) {
+		s = -s
+	}
+	d := cmplx.Sqrt(tr + 2*s)
+	return newMatrix2((m.At(0, 0)+s)/d, m.At(0, 1)/d, m.At(1, 0)/d, (m.At(1, 1)+s)/d)
+}
+
+/*
+Check the transpiled operations against the original ones.
+
+Both lists are split at their non-unitary operations, which the transpiler keeps,
+and every unitary segment is compared up to a global phase.
+Conditional gates are compared as if their conditions hold.
+*/
+func verifyTranspiled(n uint, ops, transpiled []Operation, ancillas int) error {
+	a := splitAtNonUnitary(withoutConditions(ops))
+	b := splitAtNonUnitary(withoutConditions(transpiled))
+	if len(a) != len(b) {
+		return fmt.Errorf("transpiled operations have %d non-unitary operations instead of %d", len(b)-1, len(a)-1)
+	}
+	for k := range a {
+		eq, err := equivalentWithZeroQBits(n, a[k], b[k], ancillas)
+		if err != nil {
+			return err
+		}
+		if !eq {
+			return fmt.Errorf("transpiled operations are not equivalent to the original ones (segment %d)", k)
+		}
+	}
+	return nil
+}
+
+func withoutConditions(ops []Operation) []Operation {
+	result := make([]Operation, len(ops))
+	for i, op := range ops {
+		op.Condition = nil
+		result[i] = op
+	}
+	return result
+}
+
+func splitAtNonUnitary(ops []Operation) [][]Operation {
+	segments := [][]Operation{nil}
+	for _, op := range ops {
+		if !op.IsUnitary() {
+			segments = append(segments, nil)
+			continue
+		}
+		segments[len(segments)-1] = append(segments[len(segments)-1], op)
+	}
+	return segments
+}
+
+/*
+Check whether two lists of operations act in the same way up to a global phase on the states
+where the qbits of zeroQBits are |0>.
+*/
+func equivalentWithZeroQBits(n uint, a, b []Operation, zeroQBits int) (bool, error) {
+	if zeroQBits == 0 {
+		return EquivalentOperations(n, a, b, true)
+	}
+
+	var probes []mat.Vector
+	if n <= ExactEquivalenceQBits {
+		var j uint
+		for j = 0; j < 1<<n; j++ {
+			if int(j)&zeroQBits == 0 {
+				v := mat.NewVector(1 << n)
+				v.Set(j, 1)
+				probes = append(probes, v)
+			}
+		}
+	} else {
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for k := 0; k < EquivalenceProbes; k++ {
+			v := randomState(n, rnd)
+			norm := 0.0
+			for i := range v.Data {
+				if i&zeroQBits != 0 {
+					v.Data[i] = 0
+				}
+				norm += math.Pow(cmplx.Abs(v.Data[i]), 2)
+			}
+			for i := range v.Data {
+				v.Data[i] /= complex(math.Sqrt(norm), 0)
+			}
+			probes = append(probes, v)
+		}
+	}
+
+	as := make([]mat.Vector, len(probes))
+	bs := make([]mat.Vector, len(probes))
+	for k := range probes {
+		as[k] = copyVector(probes[k])
+		bs[k] = copyVector(probes[k])
+		if err := applyOperations(&as[k], a, nil); err != nil {
+			return false, err
+		}
+		if err := applyOperations(&bs[k], b, nil); err != nil {
+			return false, err
+		}
+	}
+	return vectorsEqual(as, bs, true), nil
+}
+
+/*
+Return the basis gates which appear in operations, e.g. to check the output of Transpile.
+*/
+func BasisGates(ops []Operation) []string {
+	set := make(map[string]bool)
+	for _, op := range ops {
+		switch {
+		case op.OpName == OperationTypeNot && len(op.ControlQBits) == 1:
+			set[BasisCX] = true
+		case op.OpName == OperationTypeZ && len(op.ControlQBits) == 1:
+			set[BasisCZ] = true
+		case !op.IsUnitary() || op.OpName == OperationTypeSpace:
+			continue
+		case len(op.ControlQBits) > 0:
+			set[op.OpName] = true
+		case op.OpName == OperationTypeNot || op.OpName == OperationTypeX:
+			set[BasisX] = true
+		case op.OpName == OperationTypeSX:
+			set[BasisSX] = true
+		case op.OpName == OperationTypeHad:
+			set[BasisH] = true
+		case op.OpName == OperationTypePhase:
+			set[BasisP] = true
+		case op.OpName == OperationTypeU3:
+			set[BasisU3] = true
+		case op.OpName == OperationTypeRotate:
+			axis, _ := rotationAxis(op)
+			set["r"+strings.ToLower(axis)] = true
+		default:
+			set[op.OpName] = true
+		}
+	}
+	var names []string
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
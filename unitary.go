@@ -0,0 +1,191 @@
+package goqkit
+
+import (
+	"fmt"
+	"github.com/takezo5096/goqkit/mat"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"time"
+)
+
+/*
+Up to this number of qbits Equivalent compares full unitary matrices,
+above it random states are probed instead.
+*/
+const ExactEquivalenceQBits = 8
+
+/*
+Number of random states which Equivalent probes for large circuits.
+*/
+const EquivalenceProbes = 8
+
+const equivalenceTolerance = 1e-8
+
+/*
+Return the 2^n x 2^n unitary matrix of recorded operations.
+
+Column j is the state which the operations make from the basis state |j>.
+Read and Write operations are not unitary and return an error.
+User defined gates are looked up in DefaultGateRegistry.
+*/
+func OperationsUnitary(n uint, ops []Operation) (mat.Matrix, error) {
+	return operationsUnitary(n, ops, nil)
+}
+
+func operationsUnitary(n uint, ops []Operation, registry *GateRegistry) (mat.Matrix, error) {
+	dim := uint(1) << n
+	u := mat.NewMatrix(dim, dim)
+	var j, i uint
+	for j = 0; j < dim; j++ {
+		v := mat.NewVector(dim)
+		v.Set(j, 1)
+		if err := applyOperations(&v, ops, registry); err != nil {
+			return mat.Matrix{}, err
+		}
+		for i = 0; i < dim; i++ {
+			u.Set(i, j, v.At(i))
+		}
+	}
+	return u, nil
+}
+
+/*
+Return the unitary matrix of all operations recorded in this circuit.
+*/
+func (q *QBitsCircuit) UnitaryMatrix() (mat.Matrix, error) {
+	return operationsUnitary(q.QBitNumber, q.GetOperations(), q.GateRegistry())
+}
+
+func applyOperations(v *mat.Vector, ops []Operation, registry *GateRegistry) error {
+	for k, op := range ops {
+		if err := applyOperation(v, op, false, registry); err != nil {
+			return fmt.Errorf("operation %d: %v", k, err)
+		}
+	}
+	return nil
+}
+
+/*
+Check whether the operations recorded in two circuits implement the same unitary.
+
+Circuits up to ExactEquivalenceQBits qbits are compared by their full matrices,
+larger ones by applying both to EquivalenceProbes random states.
+
+upToGlobalPhase: regard unitaries which differ only by a global phase as equivalent
+*/
+func Equivalent(a, b *QBitsCircuit, upToGlobalPhase bool) (bool, error) {
+	if a.QBitNumber != b.QBitNumber {
+		return false, fmt.Errorf("circuits have different number of qbits: %d and %d", a.QBitNumber, b.QBitNumber)
+	}
+	return equivalentOperations(a.QBitNumber, a.GetOperations(), b.GetOperations(), a.GateRegistry(), b.GateRegistry(), upToGlobalPhase)
+}
+
+/*
+Check whether two lists of recorded operations on n qbits implement the same unitary.
+
+See Equivalent. User defined gates are looked up in DefaultGateRegistry.
+*/
+func EquivalentOperations(n uint, a, b []Operation, upToGlobalPhase bool) (bool, error) {
+	return equivalentOperations(n, a, b, nil, nil, upToGlobalPhase)
+}
+
+/*
+EquivalentOperations with the registries of the user defined gates of a and b.
+*/
+func equivalentOperations(n uint, a, b []Operation, registryA, registryB *GateRegistry, upToGlobalPhase bool) (bool, error) {
+	if n <= ExactEquivalenceQBits {
+		ua, err := operationsUnitary(n, a, registryA)
+		if err != nil {
+			return false, err
+		}
+		ub, err := operationsUnitary(n, b, registryB)
+		if err != nil {
+			return false, err
+		}
+		return matricesEqual(&ua, &ub, upToGlobalPhase), nil
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	as := make([]mat.Vector, EquivalenceProbes)
+	bs := make([]mat.Vector, EquivalenceProbes)
+	for k := 0; k < EquivalenceProbes; k++ {
+		as[k] = randomState(n, rnd)
+		bs[k] = copyVector(as[k])
+		if err := applyOperations(&as[k], a, registryA); err != nil {
+			return false, err
+		}
+		if err := applyOperations(&bs[k], b, registryB); err != nil {
+			return false, err
+		}
+	}
+	return vectorsEqual(as, bs, upToGlobalPhase), nil
+}
+
+/*
+Make a random normalized state of n qbits.
+*/
+func randomState(n uint, rnd *rand.Rand) mat.Vector {
+	v := mat.NewVector(1 << n)
+	norm := 0.0
+	for i := range v.Data {
+		v.Data[i] = complex(rnd.NormFloat64(), rnd.NormFloat64())
+		norm += real(v.Data[i])*real(v.Data[i]) + imag(v.Data[i])*imag(v.Data[i])
+	}
+	s := complex(1/math.Sqrt(norm), 0)
+	for i := range v.Data {
+		v.Data[i] *= s
+	}
+	return v
+}
+
+func matricesEqual(a, b *mat.Matrix, upToGlobalPhase bool) bool {
+	if a.Rows != b.Rows || a.Cols != b.Cols {
+		return false
+	}
+	phase := complex(1, 0)
+	if upToGlobalPhase {
+		// phase of Tr(A^dagger B)
+		var t complex128
+		for i := range a.Data {
+			for j := range a.Data[i] {
+				t += cmplx.Conj(a.Data[i][j]) * b.Data[i][j]
+			}
+		}
+		if cmplx.Abs(t) == 0 {
+			return false
+		}
+		phase = t / complex(cmplx.Abs(t), 0)
+	}
+	for i := range a.Data {
+		for j := range a.Data[i] {
+			if cmplx.Abs(a.Data[i][j]*phase-b.Data[i][j]) > equivalenceTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func vectorsEqual(as, bs []mat.Vector, upToGlobalPhase bool) bool {
+	phase := complex(1, 0)
+	if upToGlobalPhase {
+		// the same phase has to explain every probe
+		var t complex128
+		for k := range as {
+			t += innerProduct(as[k], bs[k])
+		}
+		if cmplx.Abs(t) == 0 {
+			return false
+		}
+		phase = t / complex(cmplx.Abs(t), 0)
+	}
+	for k := range as {
+		for i := range as[k].Data {
+			if cmplx.Abs(as[k].Data[i]*phase-bs[k].Data[i]) > equivalenceTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
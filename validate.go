@@ -0,0 +1,95 @@
+package goqkit
+
+import (
+	"fmt"
+)
+
+/*
+Return the first error of the gates and registers of this circuit, or nil if there is none.
+
+Gates do not return errors, so a gate with invalid qbits changes neither the qbits nor the operations
+and its error is kept until ClearErr is called. See ValidateQBits for the checks.
+
+While there is an error, all gates are skipped like invalid ones: a qbits value of a failed call,
+e.g. ToGlobalQBits of a register with an out of range value, may be 0 and would drop the control of a later gate.
+*/
+func (q *QBitsCircuit) Err() error {
+	return q.err
+}
+
+/*
+Forget the error returned by Err.
+*/
+func (q *QBitsCircuit) ClearErr() {
+	q.err = nil
+}
+
+/*
+Return the number of qbits which are not assigned to a register yet.
+*/
+func (q *QBitsCircuit) FreeQBits() int {
+	return q.qBitsQueue.Size()
+}
+
+/*
+Assign qbits for a register, or return an error if there are not enough free qbits.
+
+num: The number of qbits which you want to assign
+*/
+func (q *QBitsCircuit) AllocateQBits(num int, name string) (*Register, error) {
+	if num < 0 {
+		return nil, fmt.Errorf("register %q: number of qbits %d is negative", name, num)
+	}
+	if num > q.FreeQBits() {
+		return nil, fmt.Errorf("register %q: %d qbits requested but only %d of %d qbits are free",
+			name, num, q.FreeQBits(), q.QBitNumber)
+	}
+	return q.assignQBits(num, name), nil
+}
+
+/*
+Check that gate qbits can be used in this circuit.
+
+It is an error if a qbit is out of range of the circuit, a qbit is both target and control,
+or a qbit is not assigned to any register.
+
+val: global target qbits value
+
+controlValue: global control qbits value
+*/
+func (q *QBitsCircuit) ValidateQBits(val int, controlValue int) error {
+	return q.operationError(val, controlValue, 0)
+}
+
+func (q *QBitsCircuit) operationError(val int, control int, swap int) error {
+	all := val | control | swap
+	if out := all &^ (1<<q.QBitNumber - 1); out != 0 {
+		return fmt.Errorf("qbits %b are out of range of %d qbits", uint(out), q.QBitNumber)
+	}
+	if val&control != 0 {
+		return fmt.Errorf("qbits %b are both target and control", val&control)
+	}
+	if swap&(val|control) != 0 {
+		return fmt.Errorf("swap qbits %b are also target or control", swap&(val|control))
+	}
+	for _, qb := range qbitList(all) {
+		if q.GetRegister(int(qb)) == nil {
+			return fmt.Errorf("qbit %d is not assigned to a register", qbitIndex(qb))
+		}
+	}
+	return nil
+}
+
+/*
+Return true if the gate qbits are valid and there is no error yet, otherwise keep the first error for Err.
+*/
+func (q *QBitsCircuit) validQBits(val int, control int, swap int) bool {
+	if q.err != nil {
+		return false
+	}
+	err := q.operationError(val, control, swap)
+	if err != nil && q.err == nil {
+		q.err = err
+	}
+	return err == nil
+}
@@ -0,0 +1,95 @@
+package goqkit
+
+import (
+	"fmt"
+)
+
+/*
+Make a register of the qbits, the i-th local qbit is qbitList[i].
+*/
+func (q *QBitsCircuit) newRegister(qbitList []uint, name string) *Register {
+	var qbits uint
+	shift := int(q.QBitNumber)
+	for _, qb := range qbitList {
+		qbits |= qb
+		if qbitIndex(qb) < shift {
+			shift = qbitIndex(qb)
+		}
+	}
+	return &Register{numberOfQBits: len(qbitList), qBits: qbits, shift: shift, qbitList: qbitList, circuit: q, Name: name}
+}
+
+/*
+Return the global values of the qbits of this register, the i-th local qbit first.
+*/
+func (reg *Register) QBitList() []uint {
+	return append([]uint{}, reg.qbitList...)
+}
+
+/*
+Return the global values of the local qbits of val in local order.
+*/
+func (reg *Register) localQBitList(val int) []uint {
+	var qbits []uint
+	for i, qb := range reg.qbitList {
+		if val&(1<<uint(i)) != 0 {
+			qbits = append(qbits, qb)
+		}
+	}
+	return qbits
+}
+
+/*
+Return a view of the local qbits from to to-1 of this register, e.g. Slice(2, 3) for the single qbit 2.
+
+A view is a register which shares the qbits: gates and arithmetic on the view change this register,
+and operations are recorded with the registers which own the qbits.
+A range out of this register returns an empty view and keeps the error for Err of the circuit.
+*/
+func (reg *Register) Slice(from, to int) *Register {
+	name := fmt.Sprintf("%s[%d:%d]", reg.Name, from, to)
+	if from < 0 || to > len(reg.qbitList) || from > to {
+		if reg.circuit.err == nil {
+			reg.circuit.err = fmt.Errorf("slice [%d:%d] is out of range of register %q with %d qbits", from, to, reg.Name, reg.numberOfQBits)
+		}
+		return reg.circuit.newRegister(nil, name)
+	}
+	return reg.circuit.newRegister(append([]uint{}, reg.qbitList[from:to]...), name)
+}
+
+/*
+Return a view of qbits of any registers as one register, the i-th handle is the i-th local qbit.
+
+This is how to make a register of non contiguous qbits or of qbits in another order.
+The view shares the qbits like Slice. Invalid handles or a qbit used twice return an empty view
+and keep the error for Err.
+*/
+func (q *QBitsCircuit) Alias(name string, qbits ...QBit) *Register {
+	if _, ok := q.maskOf(qbits...); !ok {
+		return q.newRegister(nil, name)
+	}
+	var qbitList []uint
+	for _, b := range qbits {
+		qbitList = append(qbitList, uint(b.Value()))
+	}
+	return q.newRegister(qbitList, name)
+}
+
+/*
+Return a view of the qbits of the registers in order, the first register gives the lowest local qbits.
+
+See Alias.
+*/
+func (q *QBitsCircuit) Concat(name string, regs ...*Register) *Register {
+	var qbits []QBit
+	for _, reg := range regs {
+		if reg.circuit != q {
+			if q.err == nil {
+				q.err = fmt.Errorf("register %q is not a register of this circuit", reg.Name)
+			}
+			return q.newRegister(nil, name)
+		}
+		qbits = append(qbits, reg.Qs()...)
+	}
+	return q.Alias(name, qbits...)
+}